@@ -6,54 +6,352 @@
 package flags
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"math"
 	"os"
+	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 type IntVarOptions struct {
-	ShortFlag string
-	Required  bool
-	NArgs     int
+	ShortFlag     string
+	Required      bool
+	NArgs         int
+	Experimental  bool
+	RequireEquals bool
+	EnvVar        string
+	Metavar       string
 
-	Default      int
-	ValueOnExist int
-	Choices      []int
+	Default       int
+	DefaultString string
+	ValueOnExist  int
+	Choices       []int
+	// Unit of the values shown next to the metavar in the help, e.g. seconds
+	Unit string
+	// Accept values with commas between groups of digits, e.g. 1,000,000
+	AllowThousandsSeparator bool
+	// Inclusive bounds of the values, unbounded when nil
+	Min *int
+	Max *int
 }
 
 type FileVarOptions struct {
-	ShortFlag string
-	Required  bool
-	NArgs     int
+	ShortFlag     string
+	Required      bool
+	NArgs         int
+	Experimental  bool
+	RequireEquals bool
+	EnvVar        string
+	Metavar       string
 
 	Default      *os.File
 	ValueOnExist *os.File
 	Mode         string
 	Perms        os.FileMode
-	// FIXME: implement
+	// Keep track of the files opened for the flag, to close them with
+	// CloseAllOpenFiles
 	CloseOnExit bool
+	// Bind a *LazyFile that only opens the file when it is first used
+	Lazy bool
+	// Directory relative paths are resolved from, instead of the current one
+	BaseDir string
+	// Warn about the files of a slice placeholder that can't be opened and
+	// skip them, instead of failing
+	SkipUnopenable bool
 }
 
 type StringVarOptions struct {
-	ShortFlag string
-	Required  bool
-	NArgs     int
+	ShortFlag     string
+	Required      bool
+	NArgs         int
+	Experimental  bool
+	RequireEquals bool
+	EnvVar        string
+	Metavar       string
 
-	Default      string
-	ValueOnExist string
-	Choices      []string
+	Default       string
+	DefaultString string
+	ValueOnExist  string
+	Choices       []string
+	NonEmpty      bool
+	ExpandEnv     bool
+	// Strip the surrounding whitespace off values before validating and
+	// storing them, Choices are otherwise matched against the raw value
+	TrimSpace bool
+	// Regular expression the whole value has to match
+	Pattern string
+	// Path to a file listing additional choices, one per line
+	ChoicesFile string
+	// Change the case of values before validating and storing them
+	Uppercase bool
+	Lowercase bool
 }
 
 type BoolVarOptions struct {
-	ShortFlag string
-	Required  bool
-	NArgs     int
+	ShortFlag     string
+	Required      bool
+	NArgs         int
+	Experimental  bool
+	RequireEquals bool
+	EnvVar        string
+	Metavar       string
 
-	Default      bool
-	ValueOnExist bool
+	Default       bool
+	DefaultString string
+	ValueOnExist  bool
+	Toggle        bool
+	// Also accept --no-<flag>, which stores the inverse of ValueOnExist
+	Negatable bool
+	// Count the occurrences of the flag in an *int placeholder, e.g. -vvv
+	Count bool
+	// Count the occurrences of the flag down instead, e.g. -qq
+	CountDown bool
+}
+
+type PathVarOptions struct {
+	ShortFlag     string
+	Required      bool
+	NArgs         int
+	Experimental  bool
+	RequireEquals bool
+	EnvVar        string
+	Metavar       string
+
+	Default       string
+	DefaultString string
+	MustExist     bool
+	MustBeDir     bool
+	MustBeFile    bool
+	BaseDir       string
+}
+
+type FloatVarOptions struct {
+	ShortFlag     string
+	Required      bool
+	NArgs         int
+	Experimental  bool
+	RequireEquals bool
+	EnvVar        string
+	Metavar       string
+
+	Default       float64
+	DefaultString string
+	ValueOnExist  float64
+	Choices       []float64
+	// Unit of the values shown next to the metavar in the help, e.g. seconds
+	Unit string
+	// Inclusive bounds of the values, unbounded when nil
+	Min *float64
+	Max *float64
+}
+
+type Int64VarOptions struct {
+	ShortFlag     string
+	Required      bool
+	NArgs         int
+	Experimental  bool
+	RequireEquals bool
+	EnvVar        string
+	Metavar       string
+
+	Default       int64
+	DefaultString string
+	ValueOnExist  int64
+	Choices       []int64
+	// Unit of the values shown next to the metavar in the help, e.g. seconds
+	Unit string
+}
+
+type UintVarOptions struct {
+	ShortFlag     string
+	Required      bool
+	NArgs         int
+	Experimental  bool
+	RequireEquals bool
+	EnvVar        string
+	Metavar       string
+
+	Default       uint64
+	DefaultString string
+	ValueOnExist  uint64
+	Choices       []uint64
+	// Unit of the values shown next to the metavar in the help, e.g. seconds
+	Unit string
+}
+
+type PercentVarOptions struct {
+	ShortFlag     string
+	Required      bool
+	NArgs         int
+	Experimental  bool
+	RequireEquals bool
+	EnvVar        string
+	Metavar       string
+
+	Default       float64
+	DefaultString string
+}
+
+type DurationVarOptions struct {
+	ShortFlag     string
+	Required      bool
+	NArgs         int
+	Experimental  bool
+	RequireEquals bool
+	EnvVar        string
+	Metavar       string
+
+	Default       time.Duration
+	DefaultString string
+	ValueOnExist  time.Duration
+	// Reject negative durations, e.g. for timeouts
+	NonNegative bool
+	// Unit of the values given as bare numbers, e.g. time.Second for 30 to
+	// mean 30s
+	DefaultUnit time.Duration
+}
+
+type EnumVarOptions struct {
+	ShortFlag     string
+	Required      bool
+	NArgs         int
+	Experimental  bool
+	RequireEquals bool
+	EnvVar        string
+	Metavar       string
+
+	// Name of the value shown as the default in the help
+	Default string
+	// Names accepted on the command line, and the values they are stored as
+	Values map[string]int
+}
+
+// Value of a flag of a type that isn't supported natively, Set is called with
+// every parameter given to the flag
+type Value interface {
+	Set(string) error
+	String() string
+}
+
+// Whether a Value is a switch that takes no parameter, like the boolean values
+// of the flag package, which are given "true" when the flag is present
+func is_bool_value(value interface{}) bool {
+	bool_value, ok := value.(interface{ IsBoolFlag() bool })
+	return ok && bool_value.IsBoolFlag()
+}
+
+type VarOptions struct {
+	ShortFlag     string
+	Required      bool
+	NArgs         int
+	Experimental  bool
+	RequireEquals bool
+	EnvVar        string
+	Metavar       string
+
+	// Parameters accepted, checked before they are given to Set
+	Choices []string
+}
+
+// Enumeration of a user type, given by the names its String method returns,
+// e.g. "red" for a Color
+type Enum interface {
+	FromString(string) error
+	String() string
+}
+
+type enumValue struct {
+	Enum
+}
+
+func (this enumValue) Set(s string) error {
+	return this.FromString(s)
+}
+
+// Adapt an Enum to the Value given to Var, whose Choices list its names
+func EnumValue(enum Enum) Value {
+	return enumValue{enum}
+}
+
+type RangeListVarOptions struct {
+	ShortFlag     string
+	Required      bool
+	NArgs         int
+	Experimental  bool
+	RequireEquals bool
+	EnvVar        string
+	Metavar       string
+}
+
+// Kind of an Event reported by the parser
+type EventKind int
+
+const (
+	EventFlagMatched EventKind = iota
+	EventWarning
+	EventError
+)
+
+// Event is passed to the handler set with SetEventHandler: Flag and Spelling
+// are set when a flag or positional is matched, Err for warnings and errors
+type Event struct {
+	Kind     EventKind
+	Flag     string
+	Spelling string
+	Err      error
+}
+
+// FileOpenError is the error reported when a file flag can't be opened
+type FileOpenError struct {
+	Path  string
+	Mode  string
+	Perms os.FileMode
+	Err   error
+}
+
+// LazyFile opens the file at Path the first time it is read from or written to
+type LazyFile struct {
+	Path  string
+	Mode  string
+	Perms os.FileMode
+
+	fd *os.File
+}
+
+// Selection of the conventional flags registered by AddStandardFlags, the help
+// flags being set by SetHelpFlags instead (-h/--help by default)
+type StandardFlagsOptions struct {
+	// Print this version string and exit on the version flags, when not empty
+	Version string
+	// Placeholders of -v/--verbose, -q/--quiet and -c/--config, each flag is
+	// only registered if its placeholder isn't nil
+	Verbose *bool
+	Quiet   *bool
+	Config  *string
+}
+
+// ParseResult gathers what a call to ParseRich produced
+type ParseResult struct {
+	// Arguments left over once flags and positionals have been collected
+	Remaining []string
+	// Arguments passed after the "--" terminator
+	Passthrough []string
+	// Where the value of each flag that was set came from ("argv", "env",
+	// "default")
+	Sources map[string]string
+	// Warnings emitted during the parsing
+	Warnings []string
 }
 
 type ArgumentParser interface {
@@ -61,11 +359,71 @@ type ArgumentParser interface {
 	FileVar(interface{}, string, string, *FileVarOptions) error
 	StringVar(interface{}, string, string, *StringVarOptions) error
 	BoolVar(interface{}, string, string, *BoolVarOptions) error
+	PathVar(interface{}, string, string, *PathVarOptions) error
+	FloatVar(interface{}, string, string, *FloatVarOptions) error
+	Int64Var(interface{}, string, string, *Int64VarOptions) error
+	UintVar(interface{}, string, string, *UintVarOptions) error
+	DurationVar(interface{}, string, string, *DurationVarOptions) error
+	EnumVar(interface{}, string, string, *EnumVarOptions) error
+	RangeListVar(*[]int, string, string, *RangeListVarOptions) error
+	PercentVar(*float64, string, string, *PercentVarOptions) error
+	Var(Value, string, string, *VarOptions) error
+	SetOverrideVar(*map[string]interface{}, string, string) error
+	WithPrefix(string) ArgumentParser
+	MustIntVar(interface{}, string, string, *IntVarOptions)
+	MustFileVar(interface{}, string, string, *FileVarOptions)
+	MustStringVar(interface{}, string, string, *StringVarOptions)
+	MustBoolVar(interface{}, string, string, *BoolVarOptions)
+	MustPathVar(interface{}, string, string, *PathVarOptions)
+	MustFloatVar(interface{}, string, string, *FloatVarOptions)
+	MustInt64Var(interface{}, string, string, *Int64VarOptions)
+	MustUintVar(interface{}, string, string, *UintVarOptions)
+	MustPercentVar(*float64, string, string, *PercentVarOptions)
+	MustDurationVar(interface{}, string, string, *DurationVarOptions)
+	MustEnumVar(interface{}, string, string, *EnumVarOptions)
+	MustRangeListVar(*[]int, string, string, *RangeListVarOptions)
+	MustVar(Value, string, string, *VarOptions)
+	MustSetOverrideVar(*map[string]interface{}, string, string)
 
 	Parse([]string) ([]string, error)
+	ParseMap(map[string]string) error
+	ParseRich([]string) (*ParseResult, error)
+	ParseStream(io.Reader) ([]string, error)
 
+	SetHelpFlags(string, string)
+	SetVersionFlags(string, string)
+	SetReportAllMissing(bool)
+	SetOutput(io.Writer)
+	SetWarningWriter(io.Writer)
+	HelpOnEmpty(bool)
+	SetHelpSort(HelpSort)
+	SetDuplicatePolicy(DuplicatePolicy)
+	SetNegationPrefixes(...string)
+	SetStrictValues(bool)
+	DisablePositionals(bool)
+	SetStopTokens(...string)
+	MutuallyExclusive(...string) error
+	MutuallyExclusiveRequired(...string) error
+	SetContinueOnError(bool)
+	SetEventHandler(func(Event))
+	AddStandardFlags(StandardFlagsOptions) error
+	AddVerbosity(*int) error
+	Spec(string) (interface{}, error)
+	Seal()
+	Subcommand(string, string) ArgumentParser
+	AddSubcommandAlias(string, string) error
+	SelectedSubcommand() string
+	ImportStdFlagSet(*flag.FlagSet) error
+	RevalidateValues() error
+	ExportEnv(string) error
+	DumpResolved(string) error
+	MatchedSpelling(string) string
+	FlagHelp(string) (string, bool)
 	PrintHelp()
+	PrintWarning(error)
+	GenerateFishCompletion(io.Writer) error
 	CloseAllOpenFiles() error
+	Reset() error
 }
 
 type baseVar struct {
@@ -90,6 +448,9 @@ type stringVar struct {
 	baseVar
 
 	options StringVarOptions
+	pattern *regexp.Regexp
+	// Shared by the copies of the variable made while parsing
+	file_choices *[]string
 }
 
 type boolVar struct {
@@ -98,13 +459,151 @@ type boolVar struct {
 	options BoolVarOptions
 }
 
+type pathVar struct {
+	baseVar
+
+	options PathVarOptions
+}
+
+type overrideVar struct {
+	baseVar
+}
+
+type floatVar struct {
+	baseVar
+
+	options FloatVarOptions
+}
+
+type int64Var struct {
+	baseVar
+
+	options Int64VarOptions
+}
+
+type uintVar struct {
+	baseVar
+
+	options UintVarOptions
+}
+
+type percentVar struct {
+	baseVar
+
+	options PercentVarOptions
+}
+
+type durationVar struct {
+	baseVar
+
+	options DurationVarOptions
+}
+
+type enumVar struct {
+	baseVar
+
+	options EnumVarOptions
+}
+
+type valueVar struct {
+	baseVar
+
+	options VarOptions
+}
+
+type rangeListVar struct {
+	baseVar
+
+	options RangeListVarOptions
+}
+
+// Flags of which at most one can be given, or exactly one if required is set
+type exclusiveGroup struct {
+	flags    []string
+	required bool
+}
+
 type parser struct {
 	prog        string
 	description string
 
+	help_short_flag    string
+	help_long_flag     string
+	version            string
+	version_short_flag string
+	version_long_flag  string
+
+	report_all_missing bool
+
+	output         io.Writer
+	warning_writer io.Writer
+	help_on_empty  bool
+	help_sort      HelpSort
+
+	duplicate_policy DuplicatePolicy
+
+	negation_prefixes []string
+	strict_values     bool
+
+	disable_positionals bool
+	stop_tokens         []string
+	exclusive_groups    []exclusiveGroup
+
 	vars map[string]interface{}
+	// Names of the variables, in the order they were registered
+	order []string
 
 	open_fds []*os.File
+
+	sources     map[string]string
+	spellings   map[string]string
+	warnings    []string
+	passthrough []string
+
+	event_handler func(Event)
+
+	subcommands map[string]*parser
+	// Names of the subcommands, in the order they were registered
+	subcommand_order    []string
+	selected_subcommand string
+	// Subcommands the aliases stand for, e.g. commit for ci
+	subcommand_aliases map[string]string
+
+	sealed bool
+	// Changes refused once sealed, returned by the following parses
+	refused_changes []error
+
+	continue_on_error bool
+	parsing           bool
+	// Set while parsing a map, whose errors are always returned
+	parsing_map bool
+}
+
+// Behaviour of the parser when a flag is registered more than once
+type DuplicatePolicy int
+
+const (
+	DuplicateError DuplicatePolicy = iota
+	DuplicateReplace
+	DuplicateIgnore
+)
+
+// Order in which the help lists the flags
+type HelpSort int
+
+const (
+	HelpSortAlpha HelpSort = iota
+	HelpSortDeclared
+	// The flags that belong to no mutually exclusive group first, in
+	// alphabetical order, then the flags of each group in the order given
+	HelpSortGrouped
+)
+
+// Facade of a parser that registers its flags under a common prefix
+type prefixedParser struct {
+	*parser
+
+	prefix string
 }
 
 var (
@@ -112,11 +611,121 @@ var (
 	OnParsingError = DefaultOnParsingErrorCallback
 	HelpShortFlag  = "-h"
 	HelpLongFlag   = "--help"
+	// Flags that print the version set by AddStandardFlags
+	VersionShortFlag = "-V"
+	VersionLongFlag  = "--version"
+	// Name of the environment variable that enables experimental flags
+	ExperimentalEnvVar = "FLAGS_EXPERIMENTAL"
+	// Returned by Parse when ContinueOnError is set, instead of exiting after
+	// printing the help or the version
+	ErrHelp    = errors.New("Help requested")
+	ErrVersion = errors.New("Version requested")
+	// Width the help written to the given output is wrapped to
+	TerminalWidth = DefaultTerminalWidth
 )
 
+// Split the arguments that repeat a short flag into as many occurrences of it,
+// e.g. -vvv into -v -v -v
+func expand_repeated_short_flag(vars map[string]interface{}, args []string, short string) []string {
+	var expanded []string
+
+	for _, arg := range args {
+		if len(arg) > len(short) && arg == "-"+strings.Repeat(short[1:], len(arg)-1) && !is_registered_flag(vars, arg) {
+			for i := 1; i < len(arg); i++ {
+				expanded = append(expanded, short)
+			}
+		} else {
+			expanded = append(expanded, arg)
+		}
+	}
+
+	return expanded
+}
+
+// Return the index of the first argument that is the given flag, either on its
+// own or with a value assigned to it (e.g. --flag=value)
 func find_flag_idx(args []string, flag string) int {
 	for i, arg := range args {
-		if strings.HasPrefix(arg, flag) {
+		if arg == flag || strings.HasPrefix(arg, flag+"=") {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Tell whether the given argument is one of the registered flags, or one of
+// their short flags, possibly with a value assigned to it
+func is_registered_flag(vars map[string]interface{}, arg string) bool {
+	if eq_idx := strings.Index(arg, "="); eq_idx > -1 {
+		arg = arg[:eq_idx]
+	}
+
+	if _, ok := vars[arg]; ok && strings.HasPrefix(arg, "-") {
+		return true
+	}
+
+	for _, addr := range vars {
+		ShortFlag := ""
+
+		if extract_base_options(addr, &ShortFlag, new(bool), new(int), new(bool), new(bool)) == nil && ShortFlag == arg {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Tell whether the given argument is a negative number (e.g. -5 or -3.14),
+// which is a value and not a flag
+func is_negative_number(arg string) bool {
+	if !strings.HasPrefix(arg, "-") {
+		return false
+	}
+
+	if _, err := strconv.ParseFloat(arg, 64); err == nil {
+		return true
+	}
+
+	// Negative durations are offsets, e.g. -1h30m
+	_, err := time.ParseDuration(arg)
+	return err == nil
+}
+
+// Remove the commas between the groups of digits of a number, which are only
+// valid when the first group has 1 to 3 digits and the others exactly 3 (e.g.
+// 1,000,000, but neither 1,0,0 nor ,5)
+func strip_thousands_separators(value string) (string, bool) {
+	groups := strings.Split(strings.TrimLeft(value, "+-"), ",")
+	if len(groups) == 1 {
+		return value, true
+	}
+
+	for i, group := range groups {
+		if len(group) == 0 || len(group) > 3 || (i > 0 && len(group) != 3) || len(strings.Trim(group, "0123456789")) > 0 {
+			return value, false
+		}
+	}
+
+	return strings.ReplaceAll(value, ",", ""), true
+}
+
+// Tell whether what follows a short flag in the same argument is a value
+// attached to it (e.g. -I/usr/include or -n5), and not the rest of a word that
+// makes it another flag (e.g. -foo, which -f doesn't match)
+func is_attached_value(value string) bool {
+	r, _ := utf8.DecodeRuneInString(value)
+	return len(value) > 0 && !unicode.IsLetter(r)
+}
+
+// Same as find_flag_idx for a short flag that takes values, which can also be
+// attached to it (e.g. -I/usr/include) as long as the argument isn't a flag in
+// its own right
+func find_short_flag_idx(args []string, short_flag string, vars map[string]interface{}) int {
+	for i, arg := range args {
+		if arg == short_flag || strings.HasPrefix(arg, short_flag+"=") {
+			return i
+		} else if strings.HasPrefix(arg, short_flag) && is_attached_value(arg[len(short_flag):]) && !is_registered_flag(vars, arg) && !is_negative_number(arg) {
 			return i
 		}
 	}
@@ -124,24 +733,166 @@ func find_flag_idx(args []string, flag string) int {
 	return -1
 }
 
-func extract_base_options(addr interface{}, ShortFlag *string, Required *bool, NArgs *int) error {
+// Return the width of the terminal the help is written to, or the width set
+// in the COLUMNS environment variable, or 80 columns
+func DefaultTerminalWidth(w io.Writer) int {
+	if file, ok := w.(*os.File); ok {
+		if columns := terminal_columns(file.Fd()); columns > 0 {
+			return columns
+		}
+	}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+
+	return 80
+}
+
+// Split a text into lines of at most the given width, unless a single word is
+// longer than that
+func wrap_words(text string, width int) []string {
+	var lines []string
+	line := ""
+
+	for _, word := range strings.Fields(text) {
+		if len(line) == 0 {
+			line = word
+		} else if len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = word
+		} else {
+			line += " " + word
+		}
+	}
+
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// Write rows of the help whose two columns are separated by a tab, aligning the
+// second column and wrapping it to fit in the given width
+func write_help_rows(w io.Writer, rows []string, width int) {
+	column := 0
+	for _, row := range rows {
+		if left := strings.SplitN(row, "\t", 2)[0]; len(left) > column {
+			column = len(left)
+		}
+	}
+	column += 2
+
+	// Keep some room for the help text on narrow terminals
+	text_width := width - column
+	if text_width < 20 {
+		text_width = 20
+	}
+
+	for _, row := range rows {
+		cells := strings.SplitN(row, "\t", 2)
+		lines := wrap_words(cells[1], text_width)
+
+		if len(lines) == 0 {
+			fmt.Fprintln(w, cells[0])
+			continue
+		}
+
+		fmt.Fprintf(w, "%-*s%s\n", column, cells[0], lines[0])
+		for _, line := range lines[1:] {
+			fmt.Fprintf(w, "%s%s\n", strings.Repeat(" ", column), line)
+		}
+	}
+}
+
+func experimental_enabled() bool {
+	enabled, err := strconv.ParseBool(os.Getenv(ExperimentalEnvVar))
+
+	return err == nil && enabled
+}
+
+func extract_base_options(addr interface{}, ShortFlag *string, Required *bool, NArgs *int, Experimental *bool, RequireEquals *bool) error {
 	// XXX: add new types here
 	if v, isIntVarPtr := addr.(*intVar); isIntVarPtr {
 		*ShortFlag = v.options.ShortFlag
 		*Required = v.options.Required
 		*NArgs = v.options.NArgs
+		*Experimental = v.options.Experimental
+		*RequireEquals = v.options.RequireEquals
 	} else if v, isFileVarPtr := addr.(*fileVar); isFileVarPtr {
 		*ShortFlag = v.options.ShortFlag
 		*Required = v.options.Required
 		*NArgs = v.options.NArgs
+		*Experimental = v.options.Experimental
+		*RequireEquals = v.options.RequireEquals
 	} else if v, isStringVarPtr := addr.(*stringVar); isStringVarPtr {
 		*ShortFlag = v.options.ShortFlag
 		*Required = v.options.Required
 		*NArgs = v.options.NArgs
+		*Experimental = v.options.Experimental
+		*RequireEquals = v.options.RequireEquals
 	} else if v, isBoolVarPtr := addr.(*boolVar); isBoolVarPtr {
 		*ShortFlag = v.options.ShortFlag
 		*Required = v.options.Required
 		*NArgs = v.options.NArgs
+		*Experimental = v.options.Experimental
+		*RequireEquals = v.options.RequireEquals
+	} else if v, isPathVarPtr := addr.(*pathVar); isPathVarPtr {
+		*ShortFlag = v.options.ShortFlag
+		*Required = v.options.Required
+		*NArgs = v.options.NArgs
+		*Experimental = v.options.Experimental
+		*RequireEquals = v.options.RequireEquals
+	} else if _, isOverrideVarPtr := addr.(*overrideVar); isOverrideVarPtr {
+		*NArgs = 1
+	} else if v, isFloatVarPtr := addr.(*floatVar); isFloatVarPtr {
+		*ShortFlag = v.options.ShortFlag
+		*Required = v.options.Required
+		*NArgs = v.options.NArgs
+		*Experimental = v.options.Experimental
+		*RequireEquals = v.options.RequireEquals
+	} else if v, isInt64VarPtr := addr.(*int64Var); isInt64VarPtr {
+		*ShortFlag = v.options.ShortFlag
+		*Required = v.options.Required
+		*NArgs = v.options.NArgs
+		*Experimental = v.options.Experimental
+		*RequireEquals = v.options.RequireEquals
+	} else if v, isUintVarPtr := addr.(*uintVar); isUintVarPtr {
+		*ShortFlag = v.options.ShortFlag
+		*Required = v.options.Required
+		*NArgs = v.options.NArgs
+		*Experimental = v.options.Experimental
+		*RequireEquals = v.options.RequireEquals
+	} else if v, isPercentVarPtr := addr.(*percentVar); isPercentVarPtr {
+		*ShortFlag = v.options.ShortFlag
+		*Required = v.options.Required
+		*NArgs = v.options.NArgs
+		*Experimental = v.options.Experimental
+		*RequireEquals = v.options.RequireEquals
+	} else if v, isDurationVarPtr := addr.(*durationVar); isDurationVarPtr {
+		*ShortFlag = v.options.ShortFlag
+		*Required = v.options.Required
+		*NArgs = v.options.NArgs
+		*Experimental = v.options.Experimental
+		*RequireEquals = v.options.RequireEquals
+	} else if v, isEnumVarPtr := addr.(*enumVar); isEnumVarPtr {
+		*ShortFlag = v.options.ShortFlag
+		*Required = v.options.Required
+		*NArgs = v.options.NArgs
+		*Experimental = v.options.Experimental
+		*RequireEquals = v.options.RequireEquals
+	} else if v, isValueVarPtr := addr.(*valueVar); isValueVarPtr {
+		*ShortFlag = v.options.ShortFlag
+		*Required = v.options.Required
+		*NArgs = v.options.NArgs
+		*Experimental = v.options.Experimental
+		*RequireEquals = v.options.RequireEquals
+	} else if v, isRangeListVarPtr := addr.(*rangeListVar); isRangeListVarPtr {
+		*ShortFlag = v.options.ShortFlag
+		*Required = v.options.Required
+		*NArgs = v.options.NArgs
+		*Experimental = v.options.Experimental
+		*RequireEquals = v.options.RequireEquals
 	} else {
 		return fmt.Errorf("Unable to infer the type of the given variable")
 	}
@@ -151,35 +902,56 @@ func extract_base_options(addr interface{}, ShortFlag *string, Required *bool, N
 
 func parse_int_flag(parser ArgumentParser, args []string, idx int, nvar *intVar) (int, error) {
 	if nvar.options.NArgs > len(args)-idx {
-		OnParsingError(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected %d, got %d)", nvar.baseVar.flag, nvar.options.NArgs, len(args)-idx))
+		parsing_error(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected %d, got %d)", nvar.baseVar.flag, nvar.options.NArgs, len(args)-idx))
 	}
 
 	intPtr, isIntPtr := nvar.baseVar.address.(*int)
 	intSlicePtr, isIntSlicePtr := nvar.baseVar.address.(*[]int)
 
+	// A pointer to a pointer is only allocated when the flag is present
+	if intPtrPtr, isIntPtrPtr := nvar.baseVar.address.(**int); isIntPtrPtr {
+		if *intPtrPtr == nil {
+			*intPtrPtr = new(int)
+		}
+		intPtr, isIntPtr = *intPtrPtr, true
+	}
+
 	if !isIntPtr && !isIntSlicePtr {
 		return 0, fmt.Errorf("Unable to infer type of the placeholder")
 	}
 
 	if isIntPtr && nvar.options.NArgs > 1 {
-		OnParsingError(parser, fmt.Errorf("Trying to store multiple values in a single variable (%d parameters set for collection)", nvar.options.NArgs))
+		parsing_error(parser, fmt.Errorf("Trying to store multiple values in a single variable (%d parameters set for collection)", nvar.options.NArgs))
 	}
 
 	i := 0
 	for ; i < nvar.options.NArgs; i++ {
-		// FIXME: only 32bit integers are supported, no matter the architecture of the host
-		n64, err := strconv.ParseInt(args[idx+i], 0, 32)
+		value := args[idx+i]
+		if nvar.options.AllowThousandsSeparator {
+			var valid bool
+			if value, valid = strip_thousands_separators(value); !valid {
+				parsing_error(parser, fmt.Errorf("Misplaced thousands separator in the value given for flag %s (got %s)", nvar.baseVar.flag, value))
+			}
+		}
 
-		if err != nil {
-			OnParsingError(parser, fmt.Errorf("Unable to parse the value given for flag %s: %s", nvar.baseVar.flag, err.Error()))
+		// The bit size of int depends on the platform
+		n64, err := strconv.ParseInt(value, 0, strconv.IntSize)
+
+		if errors.Is(err, strconv.ErrRange) {
+			parsing_error(parser, fmt.Errorf("Value given for flag %s overflows a %d-bit integer (got %s)", nvar.baseVar.flag, strconv.IntSize, args[idx+i]))
+		} else if err != nil {
+			parsing_error(parser, fmt.Errorf("Unable to parse the value given for flag %s: %s", nvar.baseVar.flag, err.Error()))
 		}
 
 		n := int(n64)
 		if len(nvar.options.Choices) > 0 {
-			if idx := sort.SearchInts(nvar.options.Choices, n); idx >= len(nvar.options.Choices) {
-				OnParsingError(parser, fmt.Errorf("Invalid value given for flag %s (got %d)", nvar.baseVar.flag, n))
+			if !value_in_choices(n, nvar.options.Choices) {
+				parsing_error(parser, fmt.Errorf("Invalid value given for flag %s (got %d)", nvar.baseVar.flag, n))
 			}
 		}
+		if !value_in_range(n, nvar.options.Min, nvar.options.Max) {
+			parsing_error(parser, fmt.Errorf("Value given for flag %s is out of range, expected %s (got %d)", nvar.baseVar.flag, describe_range(nvar.options.Min, nvar.options.Max), n))
+		}
 
 		if isIntSlicePtr {
 			*intSlicePtr = append(*intSlicePtr, n)
@@ -191,83 +963,229 @@ func parse_int_flag(parser ArgumentParser, args []string, idx int, nvar *intVar)
 	return i, nil
 }
 
-func parse_file_flag(parser ArgumentParser, args []string, idx int, fvar *fileVar) (int, error) {
-	if fvar.options.NArgs > len(args)-idx {
-		OnParsingError(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected %d, got %d)", fvar.baseVar.flag, fvar.options.NArgs, len(args)-idx))
+// A lone - designates a standard stream, and is left as is
+func resolve_path(base_dir string, path string) string {
+	if len(base_dir) > 0 && !filepath.IsAbs(path) && path != "-" {
+		return filepath.Join(base_dir, path)
 	}
 
-	filePtr, isFilePtr := fvar.baseVar.address.(**os.File)
-	fileSlicePtr, isFileSlicePtr := fvar.baseVar.address.(*[]*os.File)
+	return path
+}
 
-	if !isFilePtr && !isFileSlicePtr {
-		return 0, fmt.Errorf("Unable to infer type of the placeholder")
+// Return the standard stream designated by - when opening a file in the given
+// mode: the standard output when writing, the standard input otherwise
+func standard_stream(mode string) *os.File {
+	if strings.ContainsAny(mode, "wa") {
+		return os.Stdout
 	}
 
-	if isFilePtr && fvar.options.NArgs > 1 {
-		OnParsingError(parser, fmt.Errorf("Trying to store multiple values in a single variable (%d parameters set for collection)", fvar.options.NArgs))
+	return os.Stdin
+}
+
+func open_file(path string, mode string, perms os.FileMode) (fd *os.File, err error) {
+	if perms == 0 {
+		perms = 0640
 	}
 
-	i := 0
-	for ; i < fvar.options.NArgs; i++ {
-		var err error
-		var fd *os.File
-		arg := args[idx+i]
+	if path == "-" {
+		return standard_stream(mode), nil
+	}
 
-		switch fvar.options.Mode {
-		case "w":
-			if fvar.options.Perms > 0 {
-				fd, err = os.OpenFile(arg, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fvar.options.Perms)
-			} else {
-				fd, err = os.OpenFile(arg, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
-			}
-		case "rw", "wr":
-			if fvar.options.Perms > 0 {
-				fd, err = os.OpenFile(arg, os.O_CREATE|os.O_TRUNC|os.O_RDWR, fvar.options.Perms)
-			} else {
-				fd, err = os.OpenFile(arg, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0640)
-			}
-		case "r":
-		default:
-			fd, err = os.Open(arg)
-		}
+	switch mode {
+	case "w":
+		fd, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perms)
+	case "a", "aw":
+		fd, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, perms)
+	case "rw", "wr":
+		fd, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, perms)
+	default:
+		fd, err = os.Open(path)
+	}
 
-		if err != nil {
-			OnParsingError(parser, fmt.Errorf("Unable to open file: %s", err))
-		} else {
-			if isFileSlicePtr {
-				*fileSlicePtr = append(*fileSlicePtr, fd)
-			} else if isFilePtr {
-				*filePtr = fd
-			}
+	if err != nil {
+		return nil, &FileOpenError{
+			Path:  path,
+			Mode:  mode,
+			Perms: perms,
+			Err:   err,
 		}
 	}
 
-	return i, nil
+	return fd, nil
 }
 
-func parse_string_flag(parser ArgumentParser, args []string, idx int, svar *stringVar) (int, error) {
-	if svar.options.NArgs > len(args)-idx {
-		OnParsingError(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected %d, got %d)", svar.baseVar.flag, svar.options.NArgs, len(args)-idx))
-	}
+func parse_lazy_file_flag(parser ArgumentParser, args []string, idx int, fvar *fileVar) (int, error) {
+	filePtr, isFilePtr := fvar.baseVar.address.(**LazyFile)
+	fileSlicePtr, isFileSlicePtr := fvar.baseVar.address.(*[]*LazyFile)
 
-	stringPtr, isStringPtr := svar.baseVar.address.(*string)
+	if !isFilePtr && !isFileSlicePtr {
+		return 0, fmt.Errorf("Unable to infer type of the placeholder")
+	}
+
+	if isFilePtr && fvar.options.NArgs > 1 {
+		parsing_error(parser, fmt.Errorf("Trying to store multiple values in a single variable (%d parameters set for collection)", fvar.options.NArgs))
+	}
+
+	i := 0
+	for ; i < fvar.options.NArgs; i++ {
+		lazy := &LazyFile{
+			Path:  resolve_path(fvar.options.BaseDir, args[idx+i]),
+			Mode:  fvar.options.Mode,
+			Perms: fvar.options.Perms,
+		}
+
+		if isFileSlicePtr {
+			*fileSlicePtr = append(*fileSlicePtr, lazy)
+		} else if isFilePtr {
+			*filePtr = lazy
+		}
+	}
+
+	return i, nil
+}
+
+func parse_file_flag(parser ArgumentParser, args []string, idx int, fvar *fileVar) (int, error) {
+	if fvar.options.NArgs > len(args)-idx {
+		parsing_error(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected %d, got %d)", fvar.baseVar.flag, fvar.options.NArgs, len(args)-idx))
+	}
+
+	if fvar.options.Lazy {
+		return parse_lazy_file_flag(parser, args, idx, fvar)
+	}
+
+	filePtr, isFilePtr := fvar.baseVar.address.(**os.File)
+	fileSlicePtr, isFileSlicePtr := fvar.baseVar.address.(*[]*os.File)
+
+	if !isFilePtr && !isFileSlicePtr {
+		return 0, fmt.Errorf("Unable to infer type of the placeholder")
+	}
+
+	if isFilePtr && fvar.options.NArgs > 1 {
+		parsing_error(parser, fmt.Errorf("Trying to store multiple values in a single variable (%d parameters set for collection)", fvar.options.NArgs))
+	}
+
+	i := 0
+	for ; i < fvar.options.NArgs; i++ {
+		fd, err := open_file(resolve_path(fvar.options.BaseDir, args[idx+i]), fvar.options.Mode, fvar.options.Perms)
+
+		if err != nil && isFileSlicePtr && fvar.options.SkipUnopenable {
+			parser.PrintWarning(err)
+		} else if err != nil {
+			parsing_error(parser, err)
+		} else {
+			if fvar.options.CloseOnExit && fd != os.Stdin && fd != os.Stdout {
+				track_file(parser, fd)
+			}
+
+			if isFileSlicePtr {
+				*fileSlicePtr = append(*fileSlicePtr, fd)
+			} else if isFilePtr {
+				*filePtr = fd
+			}
+		}
+	}
+
+	return i, nil
+}
+
+// Read the choices listed in a file, one per line, ignoring blank lines
+func load_choices_file(path string) ([]string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	choices := []string{}
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		if choice := strings.TrimSpace(scanner.Text()); len(choice) > 0 {
+			choices = append(choices, choice)
+		}
+	}
+
+	return choices, scanner.Err()
+}
+
+// Load the choices file of a variable the first time it's needed, and return
+// the choices it holds
+func string_file_choices(svar *stringVar) ([]string, error) {
+	if len(svar.options.ChoicesFile) == 0 {
+		return nil, nil
+	} else if *svar.file_choices == nil {
+		choices, err := load_choices_file(svar.options.ChoicesFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load the choices of flag %s: %s", svar.baseVar.flag, err)
+		}
+
+		*svar.file_choices = choices
+	}
+
+	return *svar.file_choices, nil
+}
+
+func parse_string_flag(parser ArgumentParser, args []string, idx int, svar *stringVar) (int, error) {
+	if svar.options.NArgs > len(args)-idx {
+		parsing_error(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected %d, got %d)", svar.baseVar.flag, svar.options.NArgs, len(args)-idx))
+	}
+
+	stringPtr, isStringPtr := svar.baseVar.address.(*string)
 	stringSlicePtr, isStringSlicePtr := svar.baseVar.address.(*[]string)
 
+	// A pointer to a pointer is only allocated when the flag is present
+	if stringPtrPtr, isStringPtrPtr := svar.baseVar.address.(**string); isStringPtrPtr {
+		if *stringPtrPtr == nil {
+			*stringPtrPtr = new(string)
+		}
+		stringPtr, isStringPtr = *stringPtrPtr, true
+	}
+
 	if !isStringPtr && !isStringSlicePtr {
 		return 0, fmt.Errorf("Unable to infer type of the placeholder")
 	}
 
-	if isStringPtr && svar.options.NArgs > 1 {
-		OnParsingError(parser, fmt.Errorf("Trying to store multiple values in a single variable (%d parameters set for collection)", svar.options.NArgs))
+	if isStringPtr {
+		if svar.options.NArgs > 1 {
+			parsing_error(parser, fmt.Errorf("Trying to store multiple values in a single variable (%d parameters set for collection)", svar.options.NArgs))
+		} else if svar.options.NArgs == 0 {
+			*stringPtr = svar.options.ValueOnExist
+		}
+	}
+
+	file_choices, err := string_file_choices(svar)
+	if err != nil {
+		parsing_error(parser, err)
 	}
 
 	i := 0
 	for ; i < svar.options.NArgs; i++ {
 		s := args[idx+i]
 
-		if len(svar.options.Choices) > 0 {
-			if idx := sort.SearchStrings(svar.options.Choices, s); idx >= len(svar.options.Choices) {
-				OnParsingError(parser, fmt.Errorf("Invalid value given for flag %s (got %d)", svar.baseVar.flag, s))
+		if svar.options.ExpandEnv {
+			s = os.ExpandEnv(s)
+		}
+
+		if svar.options.TrimSpace {
+			s = strings.TrimSpace(s)
+		}
+
+		if svar.options.Uppercase {
+			s = strings.ToUpper(s)
+		} else if svar.options.Lowercase {
+			s = strings.ToLower(s)
+		}
+
+		if svar.options.NonEmpty && len(strings.TrimSpace(s)) == 0 {
+			parsing_error(parser, fmt.Errorf("Empty value given for flag %s", svar.baseVar.flag))
+		}
+
+		if svar.pattern != nil && !svar.pattern.MatchString(s) {
+			parsing_error(parser, fmt.Errorf("Invalid value given for flag %s, expected a match for %s (got %s)", svar.baseVar.flag, svar.options.Pattern, s))
+		}
+
+		if len(svar.options.Choices) > 0 || len(svar.options.ChoicesFile) > 0 {
+			if !value_in_choices(s, svar.options.Choices) && !value_in_choices(s, file_choices) {
+				parsing_error(parser, fmt.Errorf("Invalid value given for flag %s (got %s)", svar.baseVar.flag, s))
 			}
 		}
 
@@ -283,22 +1201,44 @@ func parse_string_flag(parser ArgumentParser, args []string, idx int, svar *stri
 
 func parse_bool_flag(parser ArgumentParser, args []string, idx int, bvar *boolVar) (int, error) {
 	if bvar.options.NArgs > len(args)-idx {
-		OnParsingError(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected %d, got %d)", bvar.baseVar.flag, bvar.options.NArgs, len(args)-idx))
+		parsing_error(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected %d, got %d)", bvar.baseVar.flag, bvar.options.NArgs, len(args)-idx))
+	}
+
+	if intPtr, isIntPtr := bvar.baseVar.address.(*int); isIntPtr && bvar.options.Count {
+		*intPtr++
+		return 0, nil
+	} else if isIntPtr && bvar.options.CountDown {
+		*intPtr--
+		return 0, nil
 	}
 
 	boolPtr, isBoolPtr := bvar.baseVar.address.(*bool)
 	boolSlicePtr, isBoolSlicePtr := bvar.baseVar.address.(*[]bool)
 
+	// A pointer to a pointer is only allocated when the flag is present
+	if boolPtrPtr, isBoolPtrPtr := bvar.baseVar.address.(**bool); isBoolPtrPtr {
+		if *boolPtrPtr == nil {
+			*boolPtrPtr = new(bool)
+		}
+		boolPtr, isBoolPtr = *boolPtrPtr, true
+	}
+
 	if !isBoolPtr && !isBoolSlicePtr {
 		return 0, fmt.Errorf("Unable to infer type of the placeholder")
 	}
 
 	if isBoolPtr {
 		if bvar.options.NArgs > 1 {
-			OnParsingError(parser, fmt.Errorf("Trying to store multiple values in a single variable (%d parameters set for collection)", bvar.options.NArgs))
+			parsing_error(parser, fmt.Errorf("Trying to store multiple values in a single variable (%d parameters set for collection)", bvar.options.NArgs))
+		} else if bvar.options.Toggle && bvar.options.NArgs == 0 {
+			// Every occurrence of a toggle flips its value
+			*boolPtr = !*boolPtr
 		} else if isBoolPtr {
 			*boolPtr = bvar.options.ValueOnExist
 		}
+	} else if isBoolSlicePtr && bvar.options.NArgs == 0 {
+		// Every occurrence of a switch is collected
+		*boolSlicePtr = append(*boolSlicePtr, bvar.options.ValueOnExist)
 	}
 
 	i := 0
@@ -306,7 +1246,7 @@ func parse_bool_flag(parser ArgumentParser, args []string, idx int, bvar *boolVa
 		b, err := strconv.ParseBool(strings.ToLower(args[idx+i]))
 
 		if err != nil {
-			OnParsingError(parser, fmt.Errorf("Unable to parse the value given for flag %s: %s", bvar.baseVar.flag, err.Error()))
+			parsing_error(parser, fmt.Errorf("Unable to parse the value given for flag %s: %s", bvar.baseVar.flag, err.Error()))
 		}
 
 		if isBoolSlicePtr {
@@ -319,275 +1259,4118 @@ func parse_bool_flag(parser ArgumentParser, args []string, idx int, bvar *boolVa
 	return i, nil
 }
 
-func consume_args(parser ArgumentParser, args []string, idx int, addr interface{}) (int, error) {
-	// XXX: add new types here
-	if v, isIntVarPtr := addr.(*intVar); isIntVarPtr {
-		return parse_int_flag(parser, args, idx+1, v)
-	} else if v, isFileVarPtr := addr.(*fileVar); isFileVarPtr {
-		return parse_file_flag(parser, args, idx+1, v)
-	} else if v, isStringVarPtr := addr.(*stringVar); isStringVarPtr {
-		return parse_string_flag(parser, args, idx+1, v)
-	} else if v, isBoolVarPtr := addr.(*boolVar); isBoolVarPtr {
-		return parse_bool_flag(parser, args, idx+1, v)
+func parse_path_flag(parser ArgumentParser, args []string, idx int, pvar *pathVar) (int, error) {
+	if pvar.options.NArgs > len(args)-idx {
+		parsing_error(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected %d, got %d)", pvar.baseVar.flag, pvar.options.NArgs, len(args)-idx))
 	}
 
-	return 0, fmt.Errorf("Unable to infer the type of the given variable")
-}
+	pathPtr, isPathPtr := pvar.baseVar.address.(*string)
+	pathSlicePtr, isPathSlicePtr := pvar.baseVar.address.(*[]string)
 
-func parse_flags(parser ArgumentParser, vars map[string]interface{}, args []string) ([]string, error) {
-	for flag, addr := range vars {
-		ShortFlag := ""
-		Required := false
-		NArgs := 0
-		idx := -1
+	if !isPathPtr && !isPathSlicePtr {
+		return 0, fmt.Errorf("Unable to infer type of the placeholder")
+	}
 
-		if !strings.HasPrefix(flag, "-") {
-			continue
-		}
+	if isPathPtr && pvar.options.NArgs > 1 {
+		parsing_error(parser, fmt.Errorf("Trying to store multiple values in a single variable (%d parameters set for collection)", pvar.options.NArgs))
+	}
 
-		if err := extract_base_options(addr, &ShortFlag, &Required, &NArgs); err != nil {
-			return args, err
-		}
+	i := 0
+	for ; i < pvar.options.NArgs; i++ {
+		path := resolve_path(pvar.options.BaseDir, args[idx+i])
 
-		idx = find_flag_idx(args, flag)
-		if idx < 0 {
-			if len(ShortFlag) > 0 {
-				idx = find_flag_idx(args, ShortFlag)
-				if idx < 0 {
-					if Required {
-						OnParsingError(parser, fmt.Errorf("Missing required flag %s/%s", flag, ShortFlag))
-					} else {
-						continue
-					}
-				}
-			} else {
-				if Required {
-					OnParsingError(parser, fmt.Errorf("Missing required flag: %s", flag))
-				} else {
-					continue
-				}
+		if pvar.options.MustExist || pvar.options.MustBeDir || pvar.options.MustBeFile {
+			if info, err := os.Stat(path); err != nil {
+				parsing_error(parser, fmt.Errorf("Invalid path given for flag %s: %s", pvar.baseVar.flag, err))
+			} else if pvar.options.MustBeDir && !info.IsDir() {
+				parsing_error(parser, fmt.Errorf("Path given for flag %s is not a directory (got %s)", pvar.baseVar.flag, path))
+			} else if pvar.options.MustBeFile && !info.Mode().IsRegular() {
+				parsing_error(parser, fmt.Errorf("Path given for flag %s is not a regular file (got %s)", pvar.baseVar.flag, path))
 			}
 		}
 
-		if eq_idx := strings.Index(args[idx], "="); eq_idx > -1 {
-			if eq_idx == len(args[idx])-1 {
-				OnParsingError(parser, fmt.Errorf("No value assigned to flag %s", flag))
-			}
+		if isPathSlicePtr {
+			*pathSlicePtr = append(*pathSlicePtr, path)
+		} else if isPathPtr {
+			*pathPtr = path
+		}
+	}
 
-			param := args[idx][eq_idx+1:]
-			args[idx] = args[idx][:eq_idx]
+	return i, nil
+}
 
-			args = append(args, "")
-			copy(args[idx+2:], args[idx+1:])
-			args[idx+1] = param
-		}
+func parse_override_flag(parser ArgumentParser, args []string, idx int, ovar *overrideVar) (int, error) {
+	if len(args)-idx < 1 {
+		parsing_error(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected 1, got %d)", ovar.baseVar.flag, len(args)-idx))
+		return 0, nil
+	}
 
-		if nargs, err := consume_args(parser, args, idx, addr); err != nil {
-			return args, err
-		} else if NArgs > 0 && nargs < NArgs {
-			OnParsingError(parser, fmt.Errorf("Not enough parameters passed to flag %s", flag))
-		} else {
-			var new_args []string
+	mapPtr, isMapPtr := ovar.baseVar.address.(*map[string]interface{})
+	if !isMapPtr {
+		return 0, fmt.Errorf("Unable to infer type of the placeholder")
+	}
 
-			if idx > 0 {
-				new_args = append(new_args, args[0:idx]...)
-			}
-			if idx+nargs < len(args) {
-				new_args = append(new_args, args[nargs+idx+1:]...)
-			}
+	eq_idx := strings.Index(args[idx], "=")
+	if eq_idx < 1 {
+		parsing_error(parser, fmt.Errorf("Invalid override given for flag %s, expected key=value (got %s)", ovar.baseVar.flag, args[idx]))
+		return 1, nil
+	}
 
-			args = new_args
+	if *mapPtr == nil {
+		*mapPtr = make(map[string]interface{})
+	}
+
+	keys := strings.Split(args[idx][:eq_idx], ".")
+	current := *mapPtr
+	for _, key := range keys[:len(keys)-1] {
+		if value, ok := current[key]; !ok {
+			child := make(map[string]interface{})
+			current[key] = child
+			current = child
+		} else if child, isMap := value.(map[string]interface{}); isMap {
+			current = child
+		} else {
+			parsing_error(parser, fmt.Errorf("Override %s given for flag %s conflicts with the value already set for key %s", args[idx], ovar.baseVar.flag, key))
+			return 1, nil
 		}
 	}
+	current[keys[len(keys)-1]] = args[idx][eq_idx+1:]
 
-	return args, nil
+	return 1, nil
 }
 
-func parse_positionals(parser ArgumentParser, vars map[string]interface{}, args []string) ([]string, error) {
-	max_length_collected := 0
+func parse_float_flag(parser ArgumentParser, args []string, idx int, fvar *floatVar) (int, error) {
+	if fvar.options.NArgs > len(args)-idx {
+		parsing_error(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected %d, got %d)", fvar.baseVar.flag, fvar.options.NArgs, len(args)-idx))
+	}
 
-	for flag, addr := range vars {
-		Required := false
-		NArgs := 0
+	floatPtr, isFloatPtr := fvar.baseVar.address.(*float64)
+	floatSlicePtr, isFloatSlicePtr := fvar.baseVar.address.(*[]float64)
 
-		if strings.HasPrefix(flag, "-") {
-			continue
+	// A pointer to a pointer is only allocated when the flag is present
+	if floatPtrPtr, isFloatPtrPtr := fvar.baseVar.address.(**float64); isFloatPtrPtr {
+		if *floatPtrPtr == nil {
+			*floatPtrPtr = new(float64)
 		}
+		floatPtr, isFloatPtr = *floatPtrPtr, true
+	}
 
-		if err := extract_base_options(addr, new(string), &Required, &NArgs); err != nil {
-			return args, err
-		}
+	if !isFloatPtr && !isFloatSlicePtr {
+		return 0, fmt.Errorf("Unable to infer type of the placeholder")
+	}
 
-		svar, isStringVarPtr := addr.(*stringVar)
-		if !isStringVarPtr {
-			return nil, fmt.Errorf("Unable to infer type of the internal variable for flag %s", flag)
-		}
+	if isFloatPtr && fvar.options.NArgs > 1 {
+		parsing_error(parser, fmt.Errorf("Trying to store multiple values in a single variable (%d parameters set for collection)", fvar.options.NArgs))
+	}
 
-		stringPtr, isStringPtr := svar.baseVar.address.(*string)
-		stringSlicePtr, isStringSlicePtr := svar.baseVar.address.(*[]string)
+	i := 0
+	for ; i < fvar.options.NArgs; i++ {
+		f, err := strconv.ParseFloat(args[idx+i], 64)
 
-		if !isStringPtr && !isStringSlicePtr {
-			return nil, fmt.Errorf("Unable to infer type of the placeholder for flag %s", flag)
+		if err != nil {
+			parsing_error(parser, fmt.Errorf("Unable to parse the value given for flag %s: %s", fvar.baseVar.flag, err.Error()))
+		} else if math.IsNaN(f) || math.IsInf(f, 0) {
+			parsing_error(parser, fmt.Errorf("Invalid value given for flag %s, expected a finite number (got %s)", fvar.baseVar.flag, args[idx+i]))
 		}
 
-		length_collected := 0
-		if NArgs > 0 {
-			if len(args) < NArgs && Required {
-				OnParsingError(parser, fmt.Errorf("Not enough arguments passed to positional flag %s for collection (expected %d, got %d)", flag, NArgs, len(args)))
-			} else if len(args) > 0 {
-				if isStringSlicePtr {
-					length_collected = int(math.Min(float64(len(args)), float64(NArgs)))
-					*stringSlicePtr = append(*stringSlicePtr, args[:length_collected]...)
-				} else if isStringPtr {
-					length_collected = 1
-					*stringPtr = args[0]
-				}
-			}
-		} else {
-			if len(args) == 0 && Required {
-				OnParsingError(parser, fmt.Errorf("No arguments passed to flag %s for collection", flag))
-			} else if len(args) > 0 {
-				if isStringSlicePtr {
-					length_collected = len(args)
-					*stringSlicePtr = append(*stringSlicePtr, args[:len(args)]...)
-				} else if isStringPtr {
-					length_collected = 1
-					*stringPtr = args[0]
-				}
-			}
+		if len(fvar.options.Choices) > 0 && !value_in_choices(f, fvar.options.Choices) {
+			parsing_error(parser, fmt.Errorf("Invalid value given for flag %s (got %g)", fvar.baseVar.flag, f))
+		}
+		if !value_in_range(f, fvar.options.Min, fvar.options.Max) {
+			parsing_error(parser, fmt.Errorf("Value given for flag %s is out of range, expected %s (got %g)", fvar.baseVar.flag, describe_range(fvar.options.Min, fvar.options.Max), f))
 		}
 
-		if length_collected > max_length_collected {
-			max_length_collected = length_collected
+		if isFloatSlicePtr {
+			*floatSlicePtr = append(*floatSlicePtr, f)
+		} else if isFloatPtr {
+			*floatPtr = f
 		}
 	}
 
-	args = args[max_length_collected:]
-
-	return args, nil
+	return i, nil
 }
 
-func NewArgumentsParser(prog, description string) ArgumentParser {
-	return &parser{
-		prog:        prog,
-		description: description,
-		vars:        make(map[string]interface{}),
+func parse_int64_flag(parser ArgumentParser, args []string, idx int, nvar *int64Var) (int, error) {
+	if nvar.options.NArgs > len(args)-idx {
+		parsing_error(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected %d, got %d)", nvar.baseVar.flag, nvar.options.NArgs, len(args)-idx))
 	}
-}
 
-func DefaultOnParsingErrorCallback(parser ArgumentParser, err error) {
-	fmt.Printf("%s\n", err.Error())
-	parser.PrintHelp()
-	os.Exit(1)
-}
+	int64Ptr, isInt64Ptr := nvar.baseVar.address.(*int64)
+	int64SlicePtr, isInt64SlicePtr := nvar.baseVar.address.(*[]int64)
 
-func (this *parser) IntVar(address interface{}, flag string, help string, options *IntVarOptions) error {
-	if _, ok := this.vars[flag]; ok == true {
-		return fmt.Errorf("Flag \"%s\" was already added to the parser", flag)
+	// A pointer to a pointer is only allocated when the flag is present
+	if int64PtrPtr, isInt64PtrPtr := nvar.baseVar.address.(**int64); isInt64PtrPtr {
+		if *int64PtrPtr == nil {
+			*int64PtrPtr = new(int64)
+		}
+		int64Ptr, isInt64Ptr = *int64PtrPtr, true
 	}
 
-	if options.NArgs == 0 {
-		options.NArgs = 1
+	if !isInt64Ptr && !isInt64SlicePtr {
+		return 0, fmt.Errorf("Unable to infer type of the placeholder")
 	}
 
-	this.vars[flag] = &intVar{
-		baseVar: baseVar{
-			address: address,
-			flag:    flag,
-			help:    help,
-		},
-		options: *options,
+	if isInt64Ptr && nvar.options.NArgs > 1 {
+		parsing_error(parser, fmt.Errorf("Trying to store multiple values in a single variable (%d parameters set for collection)", nvar.options.NArgs))
 	}
 
-	return nil
-}
-
-func (this *parser) FileVar(address interface{}, flag string, help string, options *FileVarOptions) error {
-	if _, ok := this.vars[flag]; ok == true {
-		return fmt.Errorf("Flag \"%s\" was already added to the parser", flag)
-	}
+	i := 0
+	for ; i < nvar.options.NArgs; i++ {
+		n, err := strconv.ParseInt(args[idx+i], 0, 64)
 
-	if options.NArgs == 0 {
-		options.NArgs = 1
-	}
+		if err != nil {
+			parsing_error(parser, fmt.Errorf("Unable to parse the value given for flag %s: %s", nvar.baseVar.flag, err.Error()))
+		}
 
-	this.vars[flag] = &fileVar{
-		baseVar: baseVar{
-			address: address,
-			flag:    flag,
-			help:    help,
-		},
-		options: *options,
-	}
+		if len(nvar.options.Choices) > 0 && !value_in_choices(n, nvar.options.Choices) {
+			parsing_error(parser, fmt.Errorf("Invalid value given for flag %s (got %d)", nvar.baseVar.flag, n))
+		}
 
-	if options.CloseOnExit {
-		if fd, isFilePtr := address.(**os.File); isFilePtr {
-			this.open_fds = append(this.open_fds, *fd)
-		} else if fds, isFileSlicePtr := address.(*[]*os.File); isFileSlicePtr {
-			this.open_fds = append(this.open_fds, *fds...)
-		} else {
-			return fmt.Errorf("Invalid address type passed")
+		if isInt64SlicePtr {
+			*int64SlicePtr = append(*int64SlicePtr, n)
+		} else if isInt64Ptr {
+			*int64Ptr = n
 		}
 	}
 
-	return nil
+	return i, nil
 }
 
-func (this *parser) StringVar(address interface{}, flag string, help string, options *StringVarOptions) error {
-	if _, ok := this.vars[flag]; ok == true {
-		return fmt.Errorf("Flag \"%s\" was already added to the parser", flag)
+func parse_uint_flag(parser ArgumentParser, args []string, idx int, nvar *uintVar) (int, error) {
+	if nvar.options.NArgs > len(args)-idx {
+		parsing_error(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected %d, got %d)", nvar.baseVar.flag, nvar.options.NArgs, len(args)-idx))
 	}
 
-	this.vars[flag] = &stringVar{
-		baseVar: baseVar{
-			address: address,
-			flag:    flag,
-			help:    help,
-		},
-		options: *options,
+	uintPtr, isUintPtr := nvar.baseVar.address.(*uint64)
+	uintSlicePtr, isUintSlicePtr := nvar.baseVar.address.(*[]uint64)
+
+	// A pointer to a pointer is only allocated when the flag is present
+	if uintPtrPtr, isUintPtrPtr := nvar.baseVar.address.(**uint64); isUintPtrPtr {
+		if *uintPtrPtr == nil {
+			*uintPtrPtr = new(uint64)
+		}
+		uintPtr, isUintPtr = *uintPtrPtr, true
 	}
 
-	return nil
-}
+	if !isUintPtr && !isUintSlicePtr {
+		return 0, fmt.Errorf("Unable to infer type of the placeholder")
+	}
+
+	if isUintPtr && nvar.options.NArgs > 1 {
+		parsing_error(parser, fmt.Errorf("Trying to store multiple values in a single variable (%d parameters set for collection)", nvar.options.NArgs))
+	}
+
+	i := 0
+	for ; i < nvar.options.NArgs; i++ {
+		if strings.HasPrefix(args[idx+i], "-") {
+			parsing_error(parser, fmt.Errorf("Negative value given for unsigned flag %s (got %s)", nvar.baseVar.flag, args[idx+i]))
+		}
+
+		n, err := strconv.ParseUint(args[idx+i], 0, 64)
+
+		if err != nil {
+			parsing_error(parser, fmt.Errorf("Unable to parse the value given for flag %s: %s", nvar.baseVar.flag, err.Error()))
+		}
+
+		if len(nvar.options.Choices) > 0 && !value_in_choices(n, nvar.options.Choices) {
+			parsing_error(parser, fmt.Errorf("Invalid value given for flag %s (got %d)", nvar.baseVar.flag, n))
+		}
+
+		if isUintSlicePtr {
+			*uintSlicePtr = append(*uintSlicePtr, n)
+		} else if isUintPtr {
+			*uintPtr = n
+		}
+	}
+
+	return i, nil
+}
+
+// Convert a percentage (e.g. 75%) or a ratio (e.g. 0.75) to a ratio between 0
+// and 1
+func parse_percent(s string) (float64, error) {
+	ratio, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return 0, err
+	} else if strings.HasSuffix(s, "%") {
+		ratio /= 100
+	}
+
+	if math.IsNaN(ratio) || ratio < 0 || ratio > 1 {
+		return 0, fmt.Errorf("expected a value between 0%% and 100%%, or between 0 and 1 (got %s)", s)
+	}
+
+	return ratio, nil
+}
+
+func parse_percent_flag(parser ArgumentParser, args []string, idx int, pvar *percentVar) (int, error) {
+	if pvar.options.NArgs > len(args)-idx {
+		parsing_error(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected %d, got %d)", pvar.baseVar.flag, pvar.options.NArgs, len(args)-idx))
+	}
+
+	percentPtr, isPercentPtr := pvar.baseVar.address.(*float64)
+	if !isPercentPtr {
+		return 0, fmt.Errorf("Unable to infer type of the placeholder")
+	}
+
+	if pvar.options.NArgs > 1 {
+		parsing_error(parser, fmt.Errorf("Trying to store multiple values in a single variable (%d parameters set for collection)", pvar.options.NArgs))
+	}
+
+	i := 0
+	for ; i < pvar.options.NArgs; i++ {
+		ratio, err := parse_percent(args[idx+i])
+
+		if err != nil {
+			parsing_error(parser, fmt.Errorf("Invalid value given for flag %s: %s", pvar.baseVar.flag, err.Error()))
+		}
+
+		*percentPtr = ratio
+	}
+
+	return i, nil
+}
+
+// Parse a duration, a bare number being a number of the given unit unless it
+// is 0 (e.g. 30 with a unit of time.Second is 30s)
+func parse_duration(s string, unit time.Duration) (time.Duration, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil && unit != 0 {
+		// Neither NaN nor infinities are in range
+		if d := f * float64(unit); math.Abs(d) < math.MaxInt64 {
+			return time.Duration(d), nil
+		}
+	}
+
+	return time.ParseDuration(s)
+}
+
+func parse_duration_flag(parser ArgumentParser, args []string, idx int, dvar *durationVar) (int, error) {
+	if dvar.options.NArgs > len(args)-idx {
+		parsing_error(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected %d, got %d)", dvar.baseVar.flag, dvar.options.NArgs, len(args)-idx))
+	}
+
+	durationPtr, isDurationPtr := dvar.baseVar.address.(*time.Duration)
+	durationSlicePtr, isDurationSlicePtr := dvar.baseVar.address.(*[]time.Duration)
+
+	// A pointer to a pointer is only allocated when the flag is present
+	if durationPtrPtr, isDurationPtrPtr := dvar.baseVar.address.(**time.Duration); isDurationPtrPtr {
+		if *durationPtrPtr == nil {
+			*durationPtrPtr = new(time.Duration)
+		}
+		durationPtr, isDurationPtr = *durationPtrPtr, true
+	}
+
+	if !isDurationPtr && !isDurationSlicePtr {
+		return 0, fmt.Errorf("Unable to infer type of the placeholder")
+	}
+
+	if isDurationPtr && dvar.options.NArgs > 1 {
+		parsing_error(parser, fmt.Errorf("Trying to store multiple values in a single variable (%d parameters set for collection)", dvar.options.NArgs))
+	}
+
+	i := 0
+	for ; i < dvar.options.NArgs; i++ {
+		d, err := parse_duration(args[idx+i], dvar.options.DefaultUnit)
+
+		if err != nil {
+			parsing_error(parser, fmt.Errorf("Unable to parse the duration given for flag %s: %s", dvar.baseVar.flag, err.Error()))
+		} else if dvar.options.NonNegative && d < 0 {
+			parsing_error(parser, fmt.Errorf("Negative duration given for flag %s (got %s)", dvar.baseVar.flag, args[idx+i]))
+		}
+
+		if isDurationSlicePtr {
+			*durationSlicePtr = append(*durationSlicePtr, d)
+		} else if isDurationPtr {
+			*durationPtr = d
+		}
+	}
+
+	return i, nil
+}
+
+// Return the names accepted by an enum, sorted
+func enum_names(values map[string]int) []string {
+	var names []string
+
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func parse_enum_flag(parser ArgumentParser, args []string, idx int, evar *enumVar) (int, error) {
+	if evar.options.NArgs > len(args)-idx {
+		parsing_error(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected %d, got %d)", evar.baseVar.flag, evar.options.NArgs, len(args)-idx))
+	}
+
+	intPtr, isIntPtr := evar.baseVar.address.(*int)
+	intSlicePtr, isIntSlicePtr := evar.baseVar.address.(*[]int)
+
+	// A pointer to a pointer is only allocated when the flag is present
+	if intPtrPtr, isIntPtrPtr := evar.baseVar.address.(**int); isIntPtrPtr {
+		if *intPtrPtr == nil {
+			*intPtrPtr = new(int)
+		}
+		intPtr, isIntPtr = *intPtrPtr, true
+	}
+
+	if !isIntPtr && !isIntSlicePtr {
+		return 0, fmt.Errorf("Unable to infer type of the placeholder")
+	}
+
+	if isIntPtr && evar.options.NArgs > 1 {
+		parsing_error(parser, fmt.Errorf("Trying to store multiple values in a single variable (%d parameters set for collection)", evar.options.NArgs))
+	}
+
+	i := 0
+	for ; i < evar.options.NArgs; i++ {
+		value, ok := evar.options.Values[args[idx+i]]
+
+		if !ok {
+			parsing_error(parser, fmt.Errorf("Invalid value given for flag %s, expected one of %s (got %s)", evar.baseVar.flag, strings.Join(enum_names(evar.options.Values), ", "), args[idx+i]))
+		}
+
+		if isIntSlicePtr {
+			*intSlicePtr = append(*intSlicePtr, value)
+		} else if isIntPtr {
+			*intPtr = value
+		}
+	}
+
+	return i, nil
+}
+
+func parse_value_flag(parser ArgumentParser, args []string, idx int, vvar *valueVar) (int, error) {
+	if vvar.options.NArgs > len(args)-idx {
+		parsing_error(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected %d, got %d)", vvar.baseVar.flag, vvar.options.NArgs, len(args)-idx))
+	}
+
+	value, isValue := vvar.baseVar.address.(Value)
+	if !isValue {
+		return 0, fmt.Errorf("Unable to infer type of the placeholder")
+	}
+
+	if vvar.options.NArgs == 0 && is_bool_value(value) {
+		if err := value.Set("true"); err != nil {
+			parsing_error(parser, fmt.Errorf("Unable to set flag %s: %s", vvar.baseVar.flag, err.Error()))
+		}
+	}
+
+	i := 0
+	for ; i < vvar.options.NArgs; i++ {
+		if len(vvar.options.Choices) > 0 && !value_in_choices(args[idx+i], vvar.options.Choices) {
+			parsing_error(parser, fmt.Errorf("Invalid value given for flag %s (got %s)", vvar.baseVar.flag, args[idx+i]))
+		} else if err := value.Set(args[idx+i]); err != nil {
+			parsing_error(parser, fmt.Errorf("Unable to parse the value given for flag %s: %s", vvar.baseVar.flag, err.Error()))
+		}
+	}
+
+	return i, nil
+}
+
+// Expand a comma separated list of integers and inclusive ranges of integers,
+// e.g. 1-3,5 into 1, 2, 3, 5
+func parse_range_list(s string) ([]int, error) {
+	var values []int
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+
+		// The first character can be the sign of a negative start
+		sep := -1
+		if len(item) > 1 {
+			if i := strings.Index(item[1:], "-"); i > -1 {
+				sep = i + 1
+			}
+		}
+
+		if sep < 0 {
+			n, err := strconv.Atoi(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid item %q", item)
+			}
+			values = append(values, n)
+			continue
+		}
+
+		start, err := strconv.Atoi(item[:sep])
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q", item)
+		}
+		end, err := strconv.Atoi(item[sep+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q", item)
+		} else if start > end {
+			return nil, fmt.Errorf("range %q ends before it starts", item)
+		}
+
+		for n := start; n <= end; n++ {
+			values = append(values, n)
+		}
+	}
+
+	return values, nil
+}
+
+func parse_range_list_flag(parser ArgumentParser, args []string, idx int, rvar *rangeListVar) (int, error) {
+	if rvar.options.NArgs > len(args)-idx {
+		parsing_error(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected %d, got %d)", rvar.baseVar.flag, rvar.options.NArgs, len(args)-idx))
+	}
+
+	intSlicePtr, isIntSlicePtr := rvar.baseVar.address.(*[]int)
+	if !isIntSlicePtr {
+		return 0, fmt.Errorf("Unable to infer type of the placeholder")
+	}
+
+	i := 0
+	for ; i < rvar.options.NArgs; i++ {
+		values, err := parse_range_list(args[idx+i])
+
+		if err != nil {
+			parsing_error(parser, fmt.Errorf("Invalid value given for flag %s: %s", rvar.baseVar.flag, err.Error()))
+		}
+
+		*intSlicePtr = append(*intSlicePtr, values...)
+	}
+
+	return i, nil
+}
+
+func fish_quote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func fish_flag_option(flag string) string {
+	if strings.HasPrefix(flag, "--") {
+		return "-l " + flag[2:]
+	} else if len(flag) == 2 {
+		return "-s " + flag[1:]
+	}
+
+	return "-o " + flag[1:]
+}
+
+func extract_completion_details(addr interface{}, help *string, choices *[]string, isFile *bool) error {
+	// XXX: add new types here
+	if v, isIntVarPtr := addr.(*intVar); isIntVarPtr {
+		*help = v.baseVar.help
+		for _, choice := range v.options.Choices {
+			*choices = append(*choices, strconv.Itoa(choice))
+		}
+	} else if v, isFileVarPtr := addr.(*fileVar); isFileVarPtr {
+		*help = v.baseVar.help
+		*isFile = true
+	} else if v, isStringVarPtr := addr.(*stringVar); isStringVarPtr {
+		*help = v.baseVar.help
+		*choices = append(*choices, v.options.Choices...)
+	} else if v, isBoolVarPtr := addr.(*boolVar); isBoolVarPtr {
+		*help = v.baseVar.help
+	} else if v, isPathVarPtr := addr.(*pathVar); isPathVarPtr {
+		*help = v.baseVar.help
+		*isFile = true
+	} else if v, isOverrideVarPtr := addr.(*overrideVar); isOverrideVarPtr {
+		*help = v.baseVar.help
+	} else if v, isFloatVarPtr := addr.(*floatVar); isFloatVarPtr {
+		*help = v.baseVar.help
+		for _, choice := range v.options.Choices {
+			*choices = append(*choices, strconv.FormatFloat(choice, 'g', -1, 64))
+		}
+	} else if v, isInt64VarPtr := addr.(*int64Var); isInt64VarPtr {
+		*help = v.baseVar.help
+		for _, choice := range v.options.Choices {
+			*choices = append(*choices, strconv.FormatInt(choice, 10))
+		}
+	} else if v, isUintVarPtr := addr.(*uintVar); isUintVarPtr {
+		*help = v.baseVar.help
+		for _, choice := range v.options.Choices {
+			*choices = append(*choices, strconv.FormatUint(choice, 10))
+		}
+	} else if v, isPercentVarPtr := addr.(*percentVar); isPercentVarPtr {
+		*help = v.baseVar.help
+	} else if v, isDurationVarPtr := addr.(*durationVar); isDurationVarPtr {
+		*help = v.baseVar.help
+	} else if v, isEnumVarPtr := addr.(*enumVar); isEnumVarPtr {
+		*help = v.baseVar.help
+		*choices = append(*choices, enum_names(v.options.Values)...)
+	} else if v, isValueVarPtr := addr.(*valueVar); isValueVarPtr {
+		*help = v.baseVar.help
+		*choices = append(*choices, v.options.Choices...)
+	} else if v, isRangeListVarPtr := addr.(*rangeListVar); isRangeListVarPtr {
+		*help = v.baseVar.help
+	} else {
+		return fmt.Errorf("Unable to infer the type of the given variable")
+	}
+
+	return nil
+}
+
+// Return the values held by a *T, **T or *[]T placeholder, only a **T can be
+// unset
+func placeholder_values[T any](address interface{}) []T {
+	switch v := address.(type) {
+	case *T:
+		return []T{*v}
+	case **T:
+		if *v != nil {
+			return []T{**v}
+		}
+	case *[]T:
+		return *v
+	}
+
+	return nil
+}
+
+func value_in_range[T int | float64](value T, min *T, max *T) bool {
+	return (min == nil || value >= *min) && (max == nil || value <= *max)
+}
+
+// Describe the bounds of a range in the help and in the errors
+func describe_range[T int | float64](min *T, max *T) string {
+	if min != nil && max != nil {
+		return fmt.Sprintf("between %v and %v", *min, *max)
+	} else if min != nil {
+		return fmt.Sprintf("at least %v", *min)
+	} else if max != nil {
+		return fmt.Sprintf("at most %v", *max)
+	}
+
+	return ""
+}
+
+func value_in_choices[T comparable](value T, choices []T) bool {
+	for _, choice := range choices {
+		if value == choice {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Format the values held by a *T, **T or *[]T placeholder, the boolean is false
+// if the placeholder is of another type
+func format_values[T any](address interface{}, format func(T) string) ([]string, bool) {
+	var values []string
+
+	switch v := address.(type) {
+	case *T:
+		values = append(values, format(*v))
+	case **T:
+		if *v != nil {
+			values = append(values, format(**v))
+		}
+	case *[]T:
+		for _, value := range *v {
+			values = append(values, format(value))
+		}
+	default:
+		return nil, false
+	}
+
+	return values, true
+}
+
+// Format the values held by the placeholder of any of the supported types
+func format_placeholder(address interface{}) []string {
+	if value, ok := address.(Value); ok {
+		return []string{value.String()}
+	} else if values, ok := format_values(address, strconv.Itoa); ok {
+		return values
+	} else if values, ok := format_values(address, func(s string) string { return s }); ok {
+		return values
+	} else if values, ok := format_values(address, strconv.FormatBool); ok {
+		return values
+	} else if values, ok := format_values(address, func(f float64) string { return strconv.FormatFloat(f, 'g', -1, 64) }); ok {
+		return values
+	} else if values, ok := format_values(address, func(n int64) string { return strconv.FormatInt(n, 10) }); ok {
+		return values
+	} else if values, ok := format_values(address, func(n uint64) string { return strconv.FormatUint(n, 10) }); ok {
+		return values
+	} else if values, ok := format_values(address, time.Duration.String); ok {
+		return values
+	} else if values, ok := format_values(address, func(f *os.File) string {
+		if f == nil {
+			return ""
+		}
+		return f.Name()
+	}); ok {
+		return values
+	} else if values, ok := format_values(address, func(f *LazyFile) string {
+		if f == nil {
+			return ""
+		}
+		return f.Path
+	}); ok {
+		return values
+	}
+
+	return nil
+}
+
+// Return the value held by the placeholder of the given variable in a form
+// that can be serialized to JSON, files are represented by their names
+func json_value(addr interface{}) interface{} {
+	address := placeholder_address(addr)
+
+	switch address.(type) {
+	case Value, **os.File, *[]*os.File, **LazyFile, *[]*LazyFile, *time.Duration, **time.Duration, *[]time.Duration:
+		values := format_placeholder(address)
+		if is_slice_placeholder(addr) {
+			return values
+		} else if len(values) == 0 {
+			return nil
+		}
+		return values[0]
+	}
+
+	return address
+}
+
+// Name of the environment variable holding the value of a flag, e.g. the
+// value of --log-level with prefix APP_ is held by APP_LOG_LEVEL
+func env_var_name(prefix string, flag string) string {
+	return prefix + strings.ToUpper(strings.ReplaceAll(strings.TrimLeft(flag, "-"), "-", "_"))
+}
+
+// Check the values currently held by the placeholder of a variable against its
+// constraints, as they would be when parsing them
+func revalidate_var(addr interface{}) []error {
+	var errs []error
+
+	// XXX: add new types here
+	if v, isIntVarPtr := addr.(*intVar); isIntVarPtr {
+		for _, n := range placeholder_values[int](v.baseVar.address) {
+			if len(v.options.Choices) > 0 && !value_in_choices(n, v.options.Choices) {
+				errs = append(errs, fmt.Errorf("Invalid value given for flag %s (got %d)", v.baseVar.flag, n))
+			} else if !value_in_range(n, v.options.Min, v.options.Max) {
+				errs = append(errs, fmt.Errorf("Value given for flag %s is out of range, expected %s (got %d)", v.baseVar.flag, describe_range(v.options.Min, v.options.Max), n))
+			}
+		}
+	} else if v, isStringVarPtr := addr.(*stringVar); isStringVarPtr {
+		file_choices, err := string_file_choices(v)
+		if err != nil {
+			return append(errs, err)
+		}
+
+		for _, s := range placeholder_values[string](v.baseVar.address) {
+			if v.options.NonEmpty && len(strings.TrimSpace(s)) == 0 {
+				errs = append(errs, fmt.Errorf("Empty value given for flag %s", v.baseVar.flag))
+			} else if v.pattern != nil && !v.pattern.MatchString(s) {
+				errs = append(errs, fmt.Errorf("Invalid value given for flag %s, expected a match for %s (got %s)", v.baseVar.flag, v.options.Pattern, s))
+			} else if (len(v.options.Choices) > 0 || len(v.options.ChoicesFile) > 0) && !value_in_choices(s, v.options.Choices) && !value_in_choices(s, file_choices) {
+				errs = append(errs, fmt.Errorf("Invalid value given for flag %s (got %s)", v.baseVar.flag, s))
+			}
+		}
+	} else if v, isPathVarPtr := addr.(*pathVar); isPathVarPtr && (v.options.MustExist || v.options.MustBeDir || v.options.MustBeFile) {
+		for _, path := range placeholder_values[string](v.baseVar.address) {
+			if info, err := os.Stat(path); err != nil {
+				errs = append(errs, fmt.Errorf("Invalid path given for flag %s: %s", v.baseVar.flag, err))
+			} else if v.options.MustBeDir && !info.IsDir() {
+				errs = append(errs, fmt.Errorf("Path given for flag %s is not a directory (got %s)", v.baseVar.flag, path))
+			} else if v.options.MustBeFile && !info.Mode().IsRegular() {
+				errs = append(errs, fmt.Errorf("Path given for flag %s is not a regular file (got %s)", v.baseVar.flag, path))
+			}
+		}
+	} else if v, isFloatVarPtr := addr.(*floatVar); isFloatVarPtr {
+		for _, f := range placeholder_values[float64](v.baseVar.address) {
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				errs = append(errs, fmt.Errorf("Invalid value given for flag %s, expected a finite number (got %g)", v.baseVar.flag, f))
+			} else if len(v.options.Choices) > 0 && !value_in_choices(f, v.options.Choices) {
+				errs = append(errs, fmt.Errorf("Invalid value given for flag %s (got %g)", v.baseVar.flag, f))
+			} else if !value_in_range(f, v.options.Min, v.options.Max) {
+				errs = append(errs, fmt.Errorf("Value given for flag %s is out of range, expected %s (got %g)", v.baseVar.flag, describe_range(v.options.Min, v.options.Max), f))
+			}
+		}
+	} else if v, isInt64VarPtr := addr.(*int64Var); isInt64VarPtr {
+		for _, n := range placeholder_values[int64](v.baseVar.address) {
+			if len(v.options.Choices) > 0 && !value_in_choices(n, v.options.Choices) {
+				errs = append(errs, fmt.Errorf("Invalid value given for flag %s (got %d)", v.baseVar.flag, n))
+			}
+		}
+	} else if v, isUintVarPtr := addr.(*uintVar); isUintVarPtr {
+		for _, n := range placeholder_values[uint64](v.baseVar.address) {
+			if len(v.options.Choices) > 0 && !value_in_choices(n, v.options.Choices) {
+				errs = append(errs, fmt.Errorf("Invalid value given for flag %s (got %d)", v.baseVar.flag, n))
+			}
+		}
+	} else if v, isDurationVarPtr := addr.(*durationVar); isDurationVarPtr && v.options.NonNegative {
+		for _, d := range placeholder_values[time.Duration](v.baseVar.address) {
+			if d < 0 {
+				errs = append(errs, fmt.Errorf("Negative duration given for flag %s (got %s)", v.baseVar.flag, d))
+			}
+		}
+	} else if v, isEnumVarPtr := addr.(*enumVar); isEnumVarPtr {
+		var values []int
+		for _, name := range enum_names(v.options.Values) {
+			values = append(values, v.options.Values[name])
+		}
+
+		for _, n := range placeholder_values[int](v.baseVar.address) {
+			if !value_in_choices(n, values) {
+				errs = append(errs, fmt.Errorf("Invalid value given for flag %s (got %d)", v.baseVar.flag, n))
+			}
+		}
+	} else if v, isPercentVarPtr := addr.(*percentVar); isPercentVarPtr {
+		for _, ratio := range placeholder_values[float64](v.baseVar.address) {
+			if math.IsNaN(ratio) || ratio < 0 || ratio > 1 {
+				errs = append(errs, fmt.Errorf("Invalid value given for flag %s: expected a ratio between 0 and 1 (got %g)", v.baseVar.flag, ratio))
+			}
+		}
+	} else if v, isValueVarPtr := addr.(*valueVar); isValueVarPtr && len(v.options.Choices) > 0 {
+		if value, isValue := v.baseVar.address.(Value); isValue && !value_in_choices(value.String(), v.options.Choices) {
+			errs = append(errs, fmt.Errorf("Invalid value given for flag %s (got %s)", v.baseVar.flag, value.String()))
+		}
+	}
+
+	return errs
+}
+
+// Return the name of the environment variable the value of the given variable
+// falls back to
+func extract_env_var(addr interface{}) string {
+	// XXX: add new types here
+	if v, isIntVarPtr := addr.(*intVar); isIntVarPtr {
+		return v.options.EnvVar
+	} else if v, isFileVarPtr := addr.(*fileVar); isFileVarPtr {
+		return v.options.EnvVar
+	} else if v, isStringVarPtr := addr.(*stringVar); isStringVarPtr {
+		return v.options.EnvVar
+	} else if v, isBoolVarPtr := addr.(*boolVar); isBoolVarPtr {
+		return v.options.EnvVar
+	} else if v, isPathVarPtr := addr.(*pathVar); isPathVarPtr {
+		return v.options.EnvVar
+	} else if v, isFloatVarPtr := addr.(*floatVar); isFloatVarPtr {
+		return v.options.EnvVar
+	} else if v, isInt64VarPtr := addr.(*int64Var); isInt64VarPtr {
+		return v.options.EnvVar
+	} else if v, isUintVarPtr := addr.(*uintVar); isUintVarPtr {
+		return v.options.EnvVar
+	} else if v, isPercentVarPtr := addr.(*percentVar); isPercentVarPtr {
+		return v.options.EnvVar
+	} else if v, isDurationVarPtr := addr.(*durationVar); isDurationVarPtr {
+		return v.options.EnvVar
+	} else if v, isEnumVarPtr := addr.(*enumVar); isEnumVarPtr {
+		return v.options.EnvVar
+	} else if v, isValueVarPtr := addr.(*valueVar); isValueVarPtr {
+		return v.options.EnvVar
+	} else if v, isRangeListVarPtr := addr.(*rangeListVar); isRangeListVarPtr {
+		return v.options.EnvVar
+	}
+
+	return ""
+}
+
+// Name of the values of a flag in the help when none was given, the
+// alternation of its choices (e.g. {a|b|c}) or VALUE
+func extract_metavar(addr interface{}) string {
+	metavar := ""
+	// XXX: add new types here
+	if v, isIntVarPtr := addr.(*intVar); isIntVarPtr {
+		metavar = v.options.Metavar
+	} else if v, isFileVarPtr := addr.(*fileVar); isFileVarPtr {
+		metavar = v.options.Metavar
+	} else if v, isStringVarPtr := addr.(*stringVar); isStringVarPtr {
+		metavar = v.options.Metavar
+	} else if v, isBoolVarPtr := addr.(*boolVar); isBoolVarPtr {
+		metavar = v.options.Metavar
+	} else if v, isPathVarPtr := addr.(*pathVar); isPathVarPtr {
+		metavar = v.options.Metavar
+	} else if v, isFloatVarPtr := addr.(*floatVar); isFloatVarPtr {
+		metavar = v.options.Metavar
+	} else if v, isInt64VarPtr := addr.(*int64Var); isInt64VarPtr {
+		metavar = v.options.Metavar
+	} else if v, isUintVarPtr := addr.(*uintVar); isUintVarPtr {
+		metavar = v.options.Metavar
+	} else if v, isPercentVarPtr := addr.(*percentVar); isPercentVarPtr {
+		metavar = v.options.Metavar
+	} else if v, isDurationVarPtr := addr.(*durationVar); isDurationVarPtr {
+		metavar = v.options.Metavar
+	} else if v, isEnumVarPtr := addr.(*enumVar); isEnumVarPtr {
+		metavar = v.options.Metavar
+	} else if v, isValueVarPtr := addr.(*valueVar); isValueVarPtr {
+		metavar = v.options.Metavar
+	} else if v, isRangeListVarPtr := addr.(*rangeListVar); isRangeListVarPtr {
+		metavar = v.options.Metavar
+	}
+	if len(metavar) > 0 {
+		return metavar
+	}
+
+	var choices []string
+	extract_completion_details(addr, new(string), &choices, new(bool))
+	if len(choices) > 0 {
+		return "{" + strings.Join(choices, "|") + "}"
+	}
+
+	return "VALUE"
+}
+
+// Return the unit of the values of the given numeric variable
+func extract_unit(addr interface{}) string {
+	// XXX: add new types here
+	if v, isIntVarPtr := addr.(*intVar); isIntVarPtr {
+		return v.options.Unit
+	} else if v, isFloatVarPtr := addr.(*floatVar); isFloatVarPtr {
+		return v.options.Unit
+	} else if v, isInt64VarPtr := addr.(*int64Var); isInt64VarPtr {
+		return v.options.Unit
+	} else if v, isUintVarPtr := addr.(*uintVar); isUintVarPtr {
+		return v.options.Unit
+	}
+
+	return ""
+}
+
+// Whether the environment variable the given variable falls back to is set
+func has_env_value(addr interface{}) bool {
+	if env_var := extract_env_var(addr); len(env_var) > 0 {
+		_, ok := os.LookupEnv(env_var)
+		return ok
+	}
+
+	return false
+}
+
+// Return the default value of the given variable given as a string to be
+// parsed like a command line value
+func extract_default_string(addr interface{}) string {
+	// XXX: add new types here
+	if v, isIntVarPtr := addr.(*intVar); isIntVarPtr {
+		return v.options.DefaultString
+	} else if v, isStringVarPtr := addr.(*stringVar); isStringVarPtr {
+		return v.options.DefaultString
+	} else if v, isBoolVarPtr := addr.(*boolVar); isBoolVarPtr {
+		return v.options.DefaultString
+	} else if v, isPathVarPtr := addr.(*pathVar); isPathVarPtr {
+		return v.options.DefaultString
+	} else if v, isFloatVarPtr := addr.(*floatVar); isFloatVarPtr {
+		return v.options.DefaultString
+	} else if v, isInt64VarPtr := addr.(*int64Var); isInt64VarPtr {
+		return v.options.DefaultString
+	} else if v, isUintVarPtr := addr.(*uintVar); isUintVarPtr {
+		return v.options.DefaultString
+	} else if v, isPercentVarPtr := addr.(*percentVar); isPercentVarPtr {
+		return v.options.DefaultString
+	} else if v, isDurationVarPtr := addr.(*durationVar); isDurationVarPtr {
+		return v.options.DefaultString
+	}
+
+	return ""
+}
+
+// Return the default value of the given variable as shown in the help, or an
+// empty string if it has none worth mentioning
+func extract_default_value(addr interface{}) string {
+	if default_string := extract_default_string(addr); len(default_string) > 0 {
+		return default_string
+	}
+
+	// XXX: add new types here
+	if v, isIntVarPtr := addr.(*intVar); isIntVarPtr && v.options.Default != 0 {
+		return strconv.Itoa(v.options.Default)
+	} else if v, isFileVarPtr := addr.(*fileVar); isFileVarPtr && v.options.Default != nil {
+		return v.options.Default.Name()
+	} else if v, isStringVarPtr := addr.(*stringVar); isStringVarPtr && len(v.options.Default) > 0 {
+		return v.options.Default
+	} else if v, isBoolVarPtr := addr.(*boolVar); isBoolVarPtr && v.options.Default {
+		return "true"
+	} else if v, isPathVarPtr := addr.(*pathVar); isPathVarPtr && len(v.options.Default) > 0 {
+		return v.options.Default
+	} else if v, isFloatVarPtr := addr.(*floatVar); isFloatVarPtr && v.options.Default != 0 {
+		return strconv.FormatFloat(v.options.Default, 'g', -1, 64)
+	} else if v, isInt64VarPtr := addr.(*int64Var); isInt64VarPtr && v.options.Default != 0 {
+		return strconv.FormatInt(v.options.Default, 10)
+	} else if v, isUintVarPtr := addr.(*uintVar); isUintVarPtr && v.options.Default != 0 {
+		return strconv.FormatUint(v.options.Default, 10)
+	} else if v, isPercentVarPtr := addr.(*percentVar); isPercentVarPtr && v.options.Default != 0 {
+		return strconv.FormatFloat(v.options.Default*100, 'g', -1, 64) + "%"
+	} else if v, isDurationVarPtr := addr.(*durationVar); isDurationVarPtr && v.options.Default != 0 {
+		return v.options.Default.String()
+	} else if v, isEnumVarPtr := addr.(*enumVar); isEnumVarPtr && len(v.options.Default) > 0 {
+		return v.options.Default
+	}
+
+	return ""
+}
+
+// Name under which a positional argument is shown in the help, e.g. FILE...
+func positional_metavar(name string, addr interface{}) string {
+	metavar := strings.ToUpper(name)
+	if is_slice_placeholder(addr) {
+		metavar += "..."
+	}
+
+	return metavar
+}
+
+// Store the opposite of the ValueOnExist of a boolean flag given with a
+// negation prefix
+func parse_negated_bool_flag(parser ArgumentParser, bvar *boolVar) error {
+	// Negating a counted flag resets its counter
+	if intPtr, isIntPtr := bvar.baseVar.address.(*int); isIntPtr && (bvar.options.Count || bvar.options.CountDown) {
+		*intPtr = 0
+		return nil
+	}
+
+	boolPtr, isBoolPtr := bvar.baseVar.address.(*bool)
+	boolSlicePtr, isBoolSlicePtr := bvar.baseVar.address.(*[]bool)
+
+	if boolPtrPtr, isBoolPtrPtr := bvar.baseVar.address.(**bool); isBoolPtrPtr {
+		if *boolPtrPtr == nil {
+			*boolPtrPtr = new(bool)
+		}
+		boolPtr, isBoolPtr = *boolPtrPtr, true
+	}
+
+	if isBoolSlicePtr {
+		*boolSlicePtr = append(*boolSlicePtr, !bvar.options.ValueOnExist)
+	} else if isBoolPtr {
+		*boolPtr = !bvar.options.ValueOnExist
+	} else {
+		return fmt.Errorf("Unable to infer type of the placeholder")
+	}
+
+	return nil
+}
+
+// Tell whether an argument following the values consumed by a scalar flag
+// looks like one more value meant for it, e.g. 5 in --count 3 5
+func is_orphan_value(addr interface{}, arg string) bool {
+	if strings.HasPrefix(arg, "-") && !is_negative_number(arg) {
+		return false
+	}
+
+	// XXX: add new types here
+	if v, isIntVarPtr := addr.(*intVar); isIntVarPtr {
+		if _, isIntSlicePtr := v.baseVar.address.(*[]int); !isIntSlicePtr {
+			_, err := strconv.ParseInt(arg, 0, 64)
+			return err == nil
+		}
+	} else if v, isFloatVarPtr := addr.(*floatVar); isFloatVarPtr {
+		if _, isFloatSlicePtr := v.baseVar.address.(*[]float64); !isFloatSlicePtr {
+			_, err := strconv.ParseFloat(arg, 64)
+			return err == nil
+		}
+	} else if v, isBoolVarPtr := addr.(*boolVar); isBoolVarPtr && v.options.NArgs > 0 {
+		if _, isBoolSlicePtr := v.baseVar.address.(*[]bool); !isBoolSlicePtr {
+			_, err := strconv.ParseBool(strings.ToLower(arg))
+			return err == nil
+		}
+	} else if v, isInt64VarPtr := addr.(*int64Var); isInt64VarPtr {
+		if _, isInt64SlicePtr := v.baseVar.address.(*[]int64); !isInt64SlicePtr {
+			_, err := strconv.ParseInt(arg, 0, 64)
+			return err == nil
+		}
+	} else if v, isUintVarPtr := addr.(*uintVar); isUintVarPtr {
+		if _, isUintSlicePtr := v.baseVar.address.(*[]uint64); !isUintSlicePtr {
+			_, err := strconv.ParseUint(arg, 0, 64)
+			return err == nil
+		}
+	} else if _, isPercentVarPtr := addr.(*percentVar); isPercentVarPtr {
+		_, err := parse_percent(arg)
+		return err == nil
+	} else if v, isDurationVarPtr := addr.(*durationVar); isDurationVarPtr {
+		if _, isDurationSlicePtr := v.baseVar.address.(*[]time.Duration); !isDurationSlicePtr {
+			_, err := parse_duration(arg, v.options.DefaultUnit)
+			return err == nil
+		}
+	} else if v, isEnumVarPtr := addr.(*enumVar); isEnumVarPtr {
+		if _, isIntSlicePtr := v.baseVar.address.(*[]int); !isIntSlicePtr {
+			_, ok := v.options.Values[arg]
+			return ok
+		}
+	}
+
+	return false
+}
+
+func consume_args(parser ArgumentParser, args []string, idx int, addr interface{}) (int, error) {
+	// XXX: add new types here
+	if v, isIntVarPtr := addr.(*intVar); isIntVarPtr {
+		return parse_int_flag(parser, args, idx+1, v)
+	} else if v, isFileVarPtr := addr.(*fileVar); isFileVarPtr {
+		return parse_file_flag(parser, args, idx+1, v)
+	} else if v, isStringVarPtr := addr.(*stringVar); isStringVarPtr {
+		return parse_string_flag(parser, args, idx+1, v)
+	} else if v, isBoolVarPtr := addr.(*boolVar); isBoolVarPtr {
+		return parse_bool_flag(parser, args, idx+1, v)
+	} else if v, isPathVarPtr := addr.(*pathVar); isPathVarPtr {
+		return parse_path_flag(parser, args, idx+1, v)
+	} else if v, isOverrideVarPtr := addr.(*overrideVar); isOverrideVarPtr {
+		return parse_override_flag(parser, args, idx+1, v)
+	} else if v, isFloatVarPtr := addr.(*floatVar); isFloatVarPtr {
+		return parse_float_flag(parser, args, idx+1, v)
+	} else if v, isInt64VarPtr := addr.(*int64Var); isInt64VarPtr {
+		return parse_int64_flag(parser, args, idx+1, v)
+	} else if v, isUintVarPtr := addr.(*uintVar); isUintVarPtr {
+		return parse_uint_flag(parser, args, idx+1, v)
+	} else if v, isPercentVarPtr := addr.(*percentVar); isPercentVarPtr {
+		return parse_percent_flag(parser, args, idx+1, v)
+	} else if v, isDurationVarPtr := addr.(*durationVar); isDurationVarPtr {
+		return parse_duration_flag(parser, args, idx+1, v)
+	} else if v, isEnumVarPtr := addr.(*enumVar); isEnumVarPtr {
+		return parse_enum_flag(parser, args, idx+1, v)
+	} else if v, isValueVarPtr := addr.(*valueVar); isValueVarPtr {
+		return parse_value_flag(parser, args, idx+1, v)
+	} else if v, isRangeListVarPtr := addr.(*rangeListVar); isRangeListVarPtr {
+		return parse_range_list_flag(parser, args, idx+1, v)
+	}
+
+	return 0, fmt.Errorf("Unable to infer the type of the given variable")
+}
+
+// Move the value attached to the flag at index idx (from value_start on) to
+// its own argument, right after the flag truncated at flag_end
+// A new slice is returned, as the one passed to Parse belongs to the caller
+func split_flag_value(args []string, idx, flag_end, value_start int) []string {
+	new_args := make([]string, 0, len(args)+1)
+
+	new_args = append(new_args, args[:idx]...)
+	new_args = append(new_args, args[idx][:flag_end], args[idx][value_start:])
+	new_args = append(new_args, args[idx+1:]...)
+
+	return new_args
+}
+
+// Remove count arguments starting at index idx, into a new slice
+func remove_args(args []string, idx, count int) []string {
+	var new_args []string
+
+	if idx > 0 {
+		new_args = append(new_args, args[0:idx]...)
+	}
+	if idx+count <= len(args) {
+		new_args = append(new_args, args[idx+count:]...)
+	}
+
+	return new_args
+}
+
+func find_missing_required_flags(vars map[string]interface{}, order []string, args []string) ([]string, error) {
+	var missing []string
+
+	for _, flag := range order {
+		addr := vars[flag]
+		ShortFlag := ""
+		Required := false
+		NArgs := 0
+
+		if !strings.HasPrefix(flag, "-") {
+			continue
+		}
+
+		if err := extract_base_options(addr, &ShortFlag, &Required, &NArgs, new(bool), new(bool)); err != nil {
+			return nil, err
+		}
+
+		if !Required || find_flag_idx(args, flag) > -1 || has_env_value(addr) {
+			continue
+		}
+
+		if len(ShortFlag) > 0 {
+			if NArgs != 0 && find_short_flag_idx(args, ShortFlag, vars) > -1 {
+				continue
+			} else if NArgs == 0 && find_flag_idx(args, ShortFlag) > -1 {
+				continue
+			}
+
+			missing = append(missing, flag+"/"+ShortFlag)
+		} else {
+			missing = append(missing, flag)
+		}
+	}
+
+	sort.Strings(missing)
+
+	return missing, nil
+}
+
+// Count the arguments up to the next one that looks like a flag, a lone - and
+// negative numbers being values
+func count_variadic_values(args []string) int {
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "-") && arg != "-" && !is_negative_number(arg) {
+			return i
+		}
+	}
+
+	return len(args)
+}
+
+// Return a copy of the given variable that consumes exactly one value
+func single_value_var(addr interface{}) interface{} {
+	return var_with_nargs(addr, 1)
+}
+
+// Return a copy of the given variable that consumes exactly the given number
+// of values
+func var_with_nargs(addr interface{}, nargs int) interface{} {
+	// XXX: add new types here
+	if v, isIntVarPtr := addr.(*intVar); isIntVarPtr {
+		single := *v
+		single.options.NArgs = nargs
+		return &single
+	} else if v, isFileVarPtr := addr.(*fileVar); isFileVarPtr {
+		single := *v
+		single.options.NArgs = nargs
+		return &single
+	} else if v, isStringVarPtr := addr.(*stringVar); isStringVarPtr {
+		single := *v
+		single.options.NArgs = nargs
+		return &single
+	} else if v, isBoolVarPtr := addr.(*boolVar); isBoolVarPtr {
+		single := *v
+		single.options.NArgs = nargs
+		return &single
+	} else if v, isPathVarPtr := addr.(*pathVar); isPathVarPtr {
+		single := *v
+		single.options.NArgs = nargs
+		return &single
+	} else if v, isFloatVarPtr := addr.(*floatVar); isFloatVarPtr {
+		single := *v
+		single.options.NArgs = nargs
+		return &single
+	} else if v, isInt64VarPtr := addr.(*int64Var); isInt64VarPtr {
+		single := *v
+		single.options.NArgs = nargs
+		return &single
+	} else if v, isUintVarPtr := addr.(*uintVar); isUintVarPtr {
+		single := *v
+		single.options.NArgs = nargs
+		return &single
+	} else if v, isPercentVarPtr := addr.(*percentVar); isPercentVarPtr {
+		single := *v
+		single.options.NArgs = nargs
+		return &single
+	} else if v, isDurationVarPtr := addr.(*durationVar); isDurationVarPtr {
+		single := *v
+		single.options.NArgs = nargs
+		return &single
+	} else if v, isEnumVarPtr := addr.(*enumVar); isEnumVarPtr {
+		single := *v
+		single.options.NArgs = nargs
+		return &single
+	} else if v, isValueVarPtr := addr.(*valueVar); isValueVarPtr {
+		single := *v
+		single.options.NArgs = nargs
+		return &single
+	} else if v, isRangeListVarPtr := addr.(*rangeListVar); isRangeListVarPtr {
+		single := *v
+		single.options.NArgs = nargs
+		return &single
+	}
+
+	return addr
+}
+
+// Return the placeholder of the given variable
+func placeholder_address(addr interface{}) interface{} {
+	var address interface{}
+
+	// XXX: add new types here
+	if v, isIntVarPtr := addr.(*intVar); isIntVarPtr {
+		address = v.baseVar.address
+	} else if v, isFileVarPtr := addr.(*fileVar); isFileVarPtr {
+		address = v.baseVar.address
+	} else if v, isStringVarPtr := addr.(*stringVar); isStringVarPtr {
+		address = v.baseVar.address
+	} else if v, isBoolVarPtr := addr.(*boolVar); isBoolVarPtr {
+		address = v.baseVar.address
+	} else if v, isPathVarPtr := addr.(*pathVar); isPathVarPtr {
+		address = v.baseVar.address
+	} else if v, isFloatVarPtr := addr.(*floatVar); isFloatVarPtr {
+		address = v.baseVar.address
+	} else if v, isInt64VarPtr := addr.(*int64Var); isInt64VarPtr {
+		address = v.baseVar.address
+	} else if v, isUintVarPtr := addr.(*uintVar); isUintVarPtr {
+		address = v.baseVar.address
+	} else if v, isDurationVarPtr := addr.(*durationVar); isDurationVarPtr {
+		address = v.baseVar.address
+	} else if v, isEnumVarPtr := addr.(*enumVar); isEnumVarPtr {
+		address = v.baseVar.address
+	} else if v, isPercentVarPtr := addr.(*percentVar); isPercentVarPtr {
+		address = v.baseVar.address
+	} else if v, isValueVarPtr := addr.(*valueVar); isValueVarPtr {
+		address = v.baseVar.address
+	} else if v, isRangeListVarPtr := addr.(*rangeListVar); isRangeListVarPtr {
+		address = v.baseVar.address
+	}
+
+	return address
+}
+
+// Whether the placeholder of the given variable collects values into a slice
+func is_slice_placeholder(addr interface{}) bool {
+	switch placeholder_address(addr).(type) {
+	case *[]int, *[]*os.File, *[]*LazyFile, *[]string, *[]bool, *[]float64, *[]int64, *[]uint64, *[]time.Duration:
+		return true
+	}
+
+	return false
+}
+
+// Return the amount of elements held by a slice placeholder
+func slice_placeholder_length(addr interface{}) int {
+	switch v := placeholder_address(addr).(type) {
+	case *[]int:
+		return len(*v)
+	case *[]*os.File:
+		return len(*v)
+	case *[]*LazyFile:
+		return len(*v)
+	case *[]string:
+		return len(*v)
+	case *[]bool:
+		return len(*v)
+	case *[]float64:
+		return len(*v)
+	case *[]int64:
+		return len(*v)
+	case *[]uint64:
+		return len(*v)
+	case *[]time.Duration:
+		return len(*v)
+	}
+
+	return 0
+}
+
+// Empty the slice placeholder of the given variable
+func clear_slice_placeholder(addr interface{}) {
+	switch v := placeholder_address(addr).(type) {
+	case *[]int:
+		*v = nil
+	case *[]*os.File:
+		*v = nil
+	case *[]*LazyFile:
+		*v = nil
+	case *[]string:
+		*v = nil
+	case *[]bool:
+		*v = nil
+	case *[]float64:
+		*v = nil
+	case *[]int64:
+		*v = nil
+	case *[]uint64:
+		*v = nil
+	case *[]time.Duration:
+		*v = nil
+	}
+}
+
+func parse_flags(parser ArgumentParser, vars map[string]interface{}, order []string, args []string, report_all_missing bool, negation_prefixes []string, strict_values bool, sources map[string]string, spellings map[string]string) ([]string, error) {
+	var errs []error
+
+	if report_all_missing {
+		collect_error(&errs, func() error {
+			missing, err := find_missing_required_flags(vars, order, args)
+			if err != nil {
+				return err
+			}
+
+			if len(missing) > 0 {
+				parsing_error(parser, fmt.Errorf("Missing required flags: %s", strings.Join(missing, ", ")))
+			}
+
+			return nil
+		})
+	}
+
+	for _, flag := range order {
+		addr := vars[flag]
+		// Switches append a value without consuming any argument, so the
+		// elements of the placeholder are counted instead
+		length := slice_placeholder_length(addr)
+
+		if !strings.HasPrefix(flag, "-") {
+			continue
+		}
+
+		collect_error(&errs, func() error {
+			remaining, found, err := consume_flag(parser, vars, flag, args, negation_prefixes, strict_values, sources, spellings)
+			if err != nil {
+				return err
+			}
+			args = remaining
+
+			return check_flag_presence(parser, flag, addr, found, length, report_all_missing, sources)
+		})
+	}
+
+	return args, join_errors(errs)
+}
+
+// Consume every occurrence of the given flag in the arguments, and tell
+// whether there was any
+func consume_flag(parser ArgumentParser, vars map[string]interface{}, flag string, args []string, negation_prefixes []string, strict_values bool, sources map[string]string, spellings map[string]string) ([]string, bool, error) {
+	addr := vars[flag]
+	ShortFlag := ""
+	NArgs := 0
+	Experimental := false
+	RequireEquals := false
+	found := false
+
+	if err := extract_base_options(addr, &ShortFlag, new(bool), &NArgs, &Experimental, &RequireEquals); err != nil {
+		return args, found, err
+	}
+
+	// Long boolean flags can be cleared using any of the negation prefixes,
+	// e.g. --cache is negated by --disable-cache
+	var negated_flags []string
+	if v, isBoolVarPtr := addr.(*boolVar); isBoolVarPtr && strings.HasPrefix(flag, "--") {
+		for _, prefix := range negation_prefixes {
+			negated_flags = append(negated_flags, prefix+flag[2:])
+		}
+
+		if v.options.Negatable && !value_in_choices("--no-"+flag[2:], negated_flags) {
+			negated_flags = append(negated_flags, "--no-"+flag[2:])
+		}
+	}
+
+	if v, isBoolVarPtr := addr.(*boolVar); isBoolVarPtr && (v.options.Count || v.options.CountDown) && len(ShortFlag) == 2 {
+		args = expand_repeated_short_flag(vars, args, ShortFlag)
+	}
+
+	// Every occurrence of the flag is consumed: slice placeholders collect
+	// the values of all of them, scalars keep the last value given
+	for {
+		matched := flag
+		negated := false
+		idx := find_flag_idx(args, flag)
+		if len(ShortFlag) > 0 {
+			short_idx := find_flag_idx(args, ShortFlag)
+			if NArgs != 0 {
+				short_idx = find_short_flag_idx(args, ShortFlag, vars)
+			}
+
+			if short_idx > -1 && (idx < 0 || short_idx < idx) {
+				matched = ShortFlag
+				idx = short_idx
+			}
+		}
+		for _, negated_flag := range negated_flags {
+			if negated_idx := find_flag_idx(args, negated_flag); negated_idx > -1 && (idx < 0 || negated_idx < idx) {
+				matched = negated_flag
+				negated = true
+				idx = negated_idx
+			}
+		}
+		if idx < 0 {
+			break
+		}
+
+		if !found && Experimental && !experimental_enabled() {
+			parsing_error(parser, fmt.Errorf("Flag %s is experimental, set %s=1 in the environment to enable it", flag, ExperimentalEnvVar))
+		}
+		found = true
+		sources[flag] = "argv"
+		spellings[flag] = matched
+		emit_event(parser, Event{
+			Kind:     EventFlagMatched,
+			Flag:     flag,
+			Spelling: matched,
+		})
+
+		if negated {
+			if err := parse_negated_bool_flag(parser, addr.(*boolVar)); err != nil {
+				return args, found, err
+			}
+
+			args = remove_args(args, idx, 1)
+			continue
+		}
+
+		if RequireEquals && NArgs != 0 && !strings.Contains(args[idx], "=") {
+			parsing_error(parser, fmt.Errorf("Flag %s requires its value to be assigned with '=' (e.g. %s=VALUE)", flag, matched))
+		}
+
+		consumer := addr
+		assigned := false
+		if matched == ShortFlag && NArgs != 0 && len(args[idx]) > len(ShortFlag) && args[idx][len(ShortFlag)] != '=' {
+			// The value is attached to the short flag, e.g. -I/usr/include
+			args = split_flag_value(args, idx, len(ShortFlag), len(ShortFlag))
+		} else if eq_idx := strings.Index(args[idx], "="); eq_idx > -1 {
+			if eq_idx == len(args[idx])-1 {
+				parsing_error(parser, fmt.Errorf("No value assigned to flag %s", flag))
+			}
+
+			args = split_flag_value(args, idx, eq_idx, eq_idx+1)
+
+			// Switches parse the value assigned to them, e.g. --verbose=false
+			if NArgs == 0 {
+				consumer = var_with_nargs(addr, 1)
+				assigned = true
+			}
+		}
+
+		// Variadic flags consume all the values up to the next flag
+		if NArgs < 0 {
+			variadic := count_variadic_values(args[idx+1:])
+			if variadic == 0 {
+				parsing_error(parser, fmt.Errorf("Not enough parameters passed to flag %s (expected at least 1)", flag))
+			}
+
+			consumer = var_with_nargs(addr, variadic)
+		}
+
+		nargs, err := consume_args(parser, args, idx, consumer)
+		if err != nil {
+			return args, found, err
+		} else if NArgs > 0 && nargs < NArgs {
+			parsing_error(parser, fmt.Errorf("Not enough parameters passed to flag %s", flag))
+		} else if assigned && nargs == 0 {
+			parsing_error(parser, fmt.Errorf("Flag %s does not take a value", flag))
+		}
+
+		if strict_values && idx+nargs+1 < len(args) && is_orphan_value(addr, args[idx+nargs+1]) {
+			parsing_error(parser, fmt.Errorf("Unexpected value %s after flag %s, which only takes %d", args[idx+nargs+1], flag, nargs))
+		}
+
+		args = remove_args(args, idx, nargs+1)
+	}
+
+	return args, found, nil
+}
+
+// Fall back to the environment variable of a flag absent from the arguments,
+// and check that a required flag was given
+func check_flag_presence(parser ArgumentParser, flag string, addr interface{}, found bool, length int, report_all_missing bool, sources map[string]string) error {
+	ShortFlag := ""
+	Required := false
+
+	if err := extract_base_options(addr, &ShortFlag, &Required, new(int), new(bool), new(bool)); err != nil {
+		return err
+	}
+
+	// Flags absent from the arguments fall back to their environment
+	// variable, which is parsed as a single value given to the flag
+	if !found && has_env_value(addr) {
+		if _, err := consume_args(parser, []string{flag, os.Getenv(extract_env_var(addr))}, 0, single_value_var(addr)); err != nil {
+			return err
+		}
+
+		found = true
+		sources[flag] = "env"
+	}
+
+	if found && Required && is_slice_placeholder(addr) && slice_placeholder_length(addr) == length {
+		parsing_error(parser, fmt.Errorf("No values given to required flag %s", flag))
+	}
+
+	if !found && Required && !report_all_missing {
+		if len(ShortFlag) > 0 {
+			parsing_error(parser, fmt.Errorf("Missing required flag %s/%s", flag, ShortFlag))
+		} else {
+			parsing_error(parser, fmt.Errorf("Missing required flag: %s", flag))
+		}
+	}
+
+	return nil
+}
+
+func parse_positionals(parser ArgumentParser, vars map[string]interface{}, order []string, args []string, sources map[string]string) ([]string, error) {
+	for _, flag := range order {
+		addr := vars[flag]
+		Required := false
+		NArgs := 0
+
+		if strings.HasPrefix(flag, "-") {
+			continue
+		}
+
+		if err := extract_base_options(addr, new(string), &Required, &NArgs, new(bool), new(bool)); err != nil {
+			return args, err
+		}
+
+		svar, isStringVarPtr := addr.(*stringVar)
+		if !isStringVarPtr {
+			return nil, fmt.Errorf("Unable to infer type of the internal variable for flag %s", flag)
+		}
+
+		stringPtr, isStringPtr := svar.baseVar.address.(*string)
+		stringSlicePtr, isStringSlicePtr := svar.baseVar.address.(*[]string)
+
+		if !isStringPtr && !isStringSlicePtr {
+			return nil, fmt.Errorf("Unable to infer type of the placeholder for flag %s", flag)
+		}
+
+		length_collected := 0
+		if NArgs > 0 {
+			if len(args) < NArgs && Required {
+				parsing_error(parser, fmt.Errorf("Not enough arguments passed to positional flag %s for collection (expected %d, got %d)", flag, NArgs, len(args)))
+			} else if len(args) > 0 {
+				if isStringSlicePtr {
+					length_collected = int(math.Min(float64(len(args)), float64(NArgs)))
+					*stringSlicePtr = append(*stringSlicePtr, args[:length_collected]...)
+				} else if isStringPtr {
+					length_collected = 1
+					*stringPtr = args[0]
+				}
+			}
+		} else {
+			if len(args) == 0 && Required {
+				parsing_error(parser, fmt.Errorf("No arguments passed to flag %s for collection", flag))
+			} else if len(args) > 0 {
+				if isStringSlicePtr {
+					length_collected = len(args)
+					*stringSlicePtr = append(*stringSlicePtr, args[:len(args)]...)
+				} else if isStringPtr {
+					length_collected = 1
+					*stringPtr = args[0]
+				}
+			}
+		}
+
+		// Optional scalar positionals fall back to their default value
+		if length_collected > 0 {
+			sources[flag] = "argv"
+			emit_event(parser, Event{
+				Kind: EventFlagMatched,
+				Flag: flag,
+			})
+		} else if isStringPtr && len(svar.options.Default) > 0 {
+			*stringPtr = svar.options.Default
+			sources[flag] = "default"
+		}
+
+		// Positionals are handed the arguments in the order they were
+		// registered, each one after those the previous ones collected
+		args = args[length_collected:]
+	}
+
+	return args, nil
+}
+
+// Insert a prefix between the dashes and the name of a flag, e.g.
+// --timeout becomes --db-timeout, positionals are left untouched
+func prefix_flag(prefix, flag string) string {
+	name := strings.TrimLeft(flag, "-")
+	if len(name) == len(flag) {
+		return flag
+	}
+
+	return flag[:len(flag)-len(name)] + prefix + "-" + name
+}
+
+func (this *prefixedParser) IntVar(address interface{}, flag string, help string, options *IntVarOptions) error {
+	return this.parser.IntVar(address, prefix_flag(this.prefix, flag), help, options)
+}
+
+func (this *prefixedParser) FileVar(address interface{}, flag string, help string, options *FileVarOptions) error {
+	return this.parser.FileVar(address, prefix_flag(this.prefix, flag), help, options)
+}
+
+func (this *prefixedParser) StringVar(address interface{}, flag string, help string, options *StringVarOptions) error {
+	return this.parser.StringVar(address, prefix_flag(this.prefix, flag), help, options)
+}
+
+func (this *prefixedParser) BoolVar(address interface{}, flag string, help string, options *BoolVarOptions) error {
+	return this.parser.BoolVar(address, prefix_flag(this.prefix, flag), help, options)
+}
+
+func (this *prefixedParser) PathVar(address interface{}, flag string, help string, options *PathVarOptions) error {
+	return this.parser.PathVar(address, prefix_flag(this.prefix, flag), help, options)
+}
+
+func (this *prefixedParser) FloatVar(address interface{}, flag string, help string, options *FloatVarOptions) error {
+	return this.parser.FloatVar(address, prefix_flag(this.prefix, flag), help, options)
+}
+
+func (this *prefixedParser) Int64Var(address interface{}, flag string, help string, options *Int64VarOptions) error {
+	return this.parser.Int64Var(address, prefix_flag(this.prefix, flag), help, options)
+}
+
+func (this *prefixedParser) UintVar(address interface{}, flag string, help string, options *UintVarOptions) error {
+	return this.parser.UintVar(address, prefix_flag(this.prefix, flag), help, options)
+}
+
+func (this *prefixedParser) PercentVar(address *float64, flag string, help string, options *PercentVarOptions) error {
+	return this.parser.PercentVar(address, prefix_flag(this.prefix, flag), help, options)
+}
+
+func (this *prefixedParser) DurationVar(address interface{}, flag string, help string, options *DurationVarOptions) error {
+	return this.parser.DurationVar(address, prefix_flag(this.prefix, flag), help, options)
+}
+
+func (this *prefixedParser) EnumVar(address interface{}, flag string, help string, options *EnumVarOptions) error {
+	return this.parser.EnumVar(address, prefix_flag(this.prefix, flag), help, options)
+}
+
+func (this *prefixedParser) Var(value Value, flag string, help string, options *VarOptions) error {
+	return this.parser.Var(value, prefix_flag(this.prefix, flag), help, options)
+}
+
+func (this *prefixedParser) RangeListVar(address *[]int, flag string, help string, options *RangeListVarOptions) error {
+	return this.parser.RangeListVar(address, prefix_flag(this.prefix, flag), help, options)
+}
+
+func (this *prefixedParser) SetOverrideVar(address *map[string]interface{}, flag string, help string) error {
+	return this.parser.SetOverrideVar(address, prefix_flag(this.prefix, flag), help)
+}
+
+func (this *prefixedParser) WithPrefix(prefix string) ArgumentParser {
+	return this.parser.WithPrefix(this.prefix + "-" + prefix)
+}
+
+func (this *prefixedParser) Spec(spec string) (interface{}, error) {
+	return register_spec(this, spec)
+}
+
+func (this *prefixedParser) MatchedSpelling(flag string) string {
+	return this.parser.MatchedSpelling(prefix_flag(this.prefix, flag))
+}
+
+func (this *prefixedParser) FlagHelp(flag string) (string, bool) {
+	return this.parser.FlagHelp(prefix_flag(this.prefix, flag))
+}
+
+func (this *prefixedParser) ImportStdFlagSet(fs *flag.FlagSet) error {
+	return import_std_flag_set(this, fs)
+}
+
+func (this *prefixedParser) AddStandardFlags(options StandardFlagsOptions) error {
+	return add_standard_flags(this.parser, this, options)
+}
+
+func (this *prefixedParser) AddVerbosity(address *int) error {
+	return add_verbosity(this, address)
+}
+
+// Return the given flags with the prefix of the facade
+func (this *prefixedParser) prefix_flags(flags []string) []string {
+	prefixed := make([]string, len(flags))
+	for i, flag := range flags {
+		prefixed[i] = prefix_flag(this.prefix, flag)
+	}
+
+	return prefixed
+}
+
+func (this *prefixedParser) MutuallyExclusive(flags ...string) error {
+	return this.parser.MutuallyExclusive(this.prefix_flags(flags)...)
+}
+
+func (this *prefixedParser) MutuallyExclusiveRequired(flags ...string) error {
+	return this.parser.MutuallyExclusiveRequired(this.prefix_flags(flags)...)
+}
+
+func (this *prefixedParser) MustIntVar(address interface{}, flag string, help string, options *IntVarOptions) {
+	must(this.IntVar(address, flag, help, options))
+}
+
+func (this *prefixedParser) MustFileVar(address interface{}, flag string, help string, options *FileVarOptions) {
+	must(this.FileVar(address, flag, help, options))
+}
+
+func (this *prefixedParser) MustStringVar(address interface{}, flag string, help string, options *StringVarOptions) {
+	must(this.StringVar(address, flag, help, options))
+}
+
+func (this *prefixedParser) MustBoolVar(address interface{}, flag string, help string, options *BoolVarOptions) {
+	must(this.BoolVar(address, flag, help, options))
+}
+
+func (this *prefixedParser) MustPathVar(address interface{}, flag string, help string, options *PathVarOptions) {
+	must(this.PathVar(address, flag, help, options))
+}
+
+func (this *prefixedParser) MustFloatVar(address interface{}, flag string, help string, options *FloatVarOptions) {
+	must(this.FloatVar(address, flag, help, options))
+}
+
+func (this *prefixedParser) MustInt64Var(address interface{}, flag string, help string, options *Int64VarOptions) {
+	must(this.Int64Var(address, flag, help, options))
+}
+
+func (this *prefixedParser) MustUintVar(address interface{}, flag string, help string, options *UintVarOptions) {
+	must(this.UintVar(address, flag, help, options))
+}
+
+func (this *prefixedParser) MustPercentVar(address *float64, flag string, help string, options *PercentVarOptions) {
+	must(this.PercentVar(address, flag, help, options))
+}
+
+func (this *prefixedParser) MustDurationVar(address interface{}, flag string, help string, options *DurationVarOptions) {
+	must(this.DurationVar(address, flag, help, options))
+}
+
+func (this *prefixedParser) MustEnumVar(address interface{}, flag string, help string, options *EnumVarOptions) {
+	must(this.EnumVar(address, flag, help, options))
+}
+
+func (this *prefixedParser) MustVar(value Value, flag string, help string, options *VarOptions) {
+	must(this.Var(value, flag, help, options))
+}
+
+func (this *prefixedParser) MustRangeListVar(address *[]int, flag string, help string, options *RangeListVarOptions) {
+	must(this.RangeListVar(address, flag, help, options))
+}
+
+func (this *prefixedParser) MustSetOverrideVar(address *map[string]interface{}, flag string, help string) {
+	must(this.SetOverrideVar(address, flag, help))
+}
+
+func (this *FileOpenError) Error() string {
+	return fmt.Sprintf("Unable to open file: %s", this.Err)
+}
+
+func (this *FileOpenError) Unwrap() error {
+	return this.Err
+}
+
+func (this *LazyFile) open() error {
+	if this.fd != nil {
+		return nil
+	}
+
+	fd, err := open_file(this.Path, this.Mode, this.Perms)
+	if err != nil {
+		return err
+	}
+	this.fd = fd
+
+	return nil
+}
+
+func (this *LazyFile) Read(p []byte) (int, error) {
+	if err := this.open(); err != nil {
+		return 0, err
+	}
+
+	return this.fd.Read(p)
+}
+
+func (this *LazyFile) Write(p []byte) (int, error) {
+	if err := this.open(); err != nil {
+		return 0, err
+	}
+
+	return this.fd.Write(p)
+}
+
+func (this *LazyFile) Close() error {
+	if this.fd == nil {
+		return nil
+	}
+
+	// The standard streams are not owned by the file
+	var err error
+	if this.fd != os.Stdin && this.fd != os.Stdout {
+		err = this.fd.Close()
+	}
+	this.fd = nil
+
+	return err
+}
+
+// Check that the placeholder of a flag is a *T or *[]T, or a **T allocated
+// when the flag is given if optional is set
+func check_placeholder[T any](flag string, address interface{}, optional bool) error {
+	switch address.(type) {
+	case *T, *[]T:
+		return nil
+	case **T:
+		if optional {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Invalid placeholder for flag %s, expected a %T or a %T (got %T)", flag, new(T), new([]T), address)
+}
+
+// Short flags are made of a single dash followed by a single character
+func (this *parser) check_short_flag(flag string, short_flag string) error {
+	if runes := []rune(short_flag); len(runes) > 0 && (len(runes) != 2 || runes[0] != '-' || runes[1] == '-') {
+		return fmt.Errorf("Invalid short flag \"%s\", expected a dash followed by a single character", short_flag)
+	} else if len(short_flag) == 0 {
+		return nil
+	}
+
+	// Short flags aren't prefixed by the facades, which can't share them
+	for other, addr := range this.vars {
+		ShortFlag := ""
+
+		if extract_base_options(addr, &ShortFlag, new(bool), new(int), new(bool), new(bool)) == nil && other != flag && ShortFlag == short_flag {
+			return fmt.Errorf("Short flag %s of flag %s is already used by flag %s", short_flag, flag, other)
+		}
+	}
+
+	return nil
+}
+
+// Return an error if the configuration of the parser can no longer change
+func (this *parser) check_unsealed() error {
+	if this.sealed {
+		return fmt.Errorf("The parser is sealed, its configuration can't be changed")
+	}
+
+	return nil
+}
+
+// Record a change to the configuration refused by a setter, which has no error
+// to return, to have it returned by the next parse instead
+func (this *parser) refuse_change(err error) {
+	this.refused_changes = append(this.refused_changes, err)
+}
+
+// Return the changes refused since the last parse, which are only reported once
+func (this *parser) report_refused_changes() error {
+	err := errors.Join(this.refused_changes...)
+	this.refused_changes = nil
+
+	return err
+}
+
+func (this *parser) add_var(flag string, v interface{}) {
+	if _, ok := this.vars[flag]; !ok {
+		this.order = append(this.order, flag)
+	}
+
+	this.vars[flag] = v
+}
+
+// Store the default value given as a string to a variable as if it was given
+// on the command line, before registering it so that a value that doesn't
+// convert leaves the parser untouched
+func (this *parser) apply_default_string(addr interface{}, flag string, value string) error {
+	if is_slice_placeholder(addr) {
+		return fmt.Errorf("Flag %s needs a scalar placeholder to have a default value", flag)
+	}
+
+	return this.convert_default_string(addr, flag, value)
+}
+
+// Parse a value given to a variable, returning the errors instead of reporting
+// them
+func (this *parser) convert_default_string(addr interface{}, flag string, value string) (err error) {
+	continue_on_error, parsing, event_handler := this.continue_on_error, this.parsing, this.event_handler
+	this.continue_on_error, this.parsing, this.event_handler = true, true, nil
+	defer func() {
+		this.continue_on_error, this.parsing, this.event_handler = continue_on_error, parsing, event_handler
+	}()
+	defer this.end_parsing(&err)
+
+	_, err = consume_args(this, []string{flag, value}, 0, single_value_var(addr))
+	return err
+}
+
+// Tell whether the registration of a flag should go through, according to
+// the duplicate policy of the parser
+func (this *parser) accept_registration(flag string) (bool, error) {
+	if err := this.check_unsealed(); err != nil {
+		return false, err
+	}
+
+	if _, ok := this.vars[flag]; ok == true {
+		switch this.duplicate_policy {
+		case DuplicateReplace:
+			return true, nil
+		case DuplicateIgnore:
+			return false, nil
+		default:
+			return false, fmt.Errorf("Flag \"%s\" was already added to the parser", flag)
+		}
+	}
+
+	return true, nil
+}
+
+// Split a spec string on whitespace, keeping quoted values whole, e.g.
+// help='the count' is a single token whose value is unquoted
+func split_spec(spec string) ([]string, error) {
+	var tokens []string
+	var token strings.Builder
+	quote := rune(0)
+	in_token := false
+
+	for _, c := range spec {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			token.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote = c
+			in_token = true
+		case c == ' ' || c == '\t':
+			if in_token {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				in_token = false
+			}
+		default:
+			token.WriteRune(c)
+			in_token = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("Unterminated quote in spec \"%s\"", spec)
+	} else if in_token {
+		tokens = append(tokens, token.String())
+	}
+
+	return tokens, nil
+}
+
+// Register the flag described by a spec string on the given parser, and return
+// a pointer to the variable that holds its value
+func register_spec(parser ArgumentParser, spec string) (interface{}, error) {
+	tokens, err := split_spec(spec)
+	if err != nil {
+		return nil, err
+	} else if len(tokens) == 0 {
+		return nil, fmt.Errorf("Empty spec")
+	}
+
+	flag := ""
+	short_flag := ""
+	for _, name := range strings.Split(tokens[0], ",") {
+		if len(name) == 2 && name[0] == '-' && name[1] != '-' {
+			short_flag = name
+		} else {
+			flag = name
+		}
+	}
+	if len(flag) == 0 {
+		flag, short_flag = short_flag, ""
+	}
+
+	kind := "string"
+	required := false
+	help := ""
+	default_value := ""
+	has_default := false
+
+	for i, token := range tokens[1:] {
+		switch {
+		case token == "required":
+			required = true
+		case strings.HasPrefix(token, "help="):
+			help = token[len("help="):]
+		case strings.HasPrefix(token, "default="):
+			default_value = token[len("default="):]
+			has_default = true
+		case i == 0 && !strings.Contains(token, "="):
+			kind = token
+		default:
+			return nil, fmt.Errorf("Unknown attribute \"%s\" in spec \"%s\"", token, spec)
+		}
+	}
+
+	switch kind {
+	case "int":
+		address := new(int)
+		options := &IntVarOptions{ShortFlag: short_flag, Required: required}
+		if has_default {
+			if options.Default, err = strconv.Atoi(default_value); err != nil {
+				return nil, fmt.Errorf("Invalid default value in spec \"%s\": %s", spec, err)
+			}
+			*address = options.Default
+		}
+
+		return address, parser.IntVar(address, flag, help, options)
+	case "float":
+		address := new(float64)
+		options := &FloatVarOptions{ShortFlag: short_flag, Required: required}
+		if has_default {
+			if options.Default, err = strconv.ParseFloat(default_value, 64); err != nil {
+				return nil, fmt.Errorf("Invalid default value in spec \"%s\": %s", spec, err)
+			}
+			*address = options.Default
+		}
+
+		return address, parser.FloatVar(address, flag, help, options)
+	case "duration":
+		address := new(time.Duration)
+		options := &DurationVarOptions{ShortFlag: short_flag, Required: required}
+		if has_default {
+			if options.Default, err = time.ParseDuration(default_value); err != nil {
+				return nil, fmt.Errorf("Invalid default value in spec \"%s\": %s", spec, err)
+			}
+			*address = options.Default
+		}
+
+		return address, parser.DurationVar(address, flag, help, options)
+	case "bool":
+		address := new(bool)
+		options := &BoolVarOptions{ShortFlag: short_flag, Required: required, ValueOnExist: true}
+		if has_default {
+			if options.Default, err = strconv.ParseBool(default_value); err != nil {
+				return nil, fmt.Errorf("Invalid default value in spec \"%s\": %s", spec, err)
+			}
+			*address = options.Default
+		}
+
+		return address, parser.BoolVar(address, flag, help, options)
+	case "string", "path":
+		address := new(string)
+		*address = default_value
+
+		if kind == "path" {
+			return address, parser.PathVar(address, flag, help, &PathVarOptions{ShortFlag: short_flag, Required: required, Default: default_value})
+		}
+
+		return address, parser.StringVar(address, flag, help, &StringVarOptions{ShortFlag: short_flag, Required: required, NArgs: 1, Default: default_value})
+	}
+
+	return nil, fmt.Errorf("Unknown type \"%s\" in spec \"%s\"", kind, spec)
+}
+
+// Panic on registration errors, which are programming mistakes
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func NewArgumentsParser(prog, description string) ArgumentParser {
+	return &parser{
+		prog:               prog,
+		description:        description,
+		help_short_flag:    HelpShortFlag,
+		help_long_flag:     HelpLongFlag,
+		version_short_flag: VersionShortFlag,
+		version_long_flag:  VersionLongFlag,
+		output:             os.Stdout,
+		warning_writer:     os.Stderr,
+		vars:               make(map[string]interface{}),
+	}
+}
+
+// Implemented by the parsers that can report events to a handler
+type eventEmitter interface {
+	emit(event Event)
+}
+
+func emit_event(parser ArgumentParser, event Event) {
+	if emitter, ok := parser.(eventEmitter); ok {
+		emitter.emit(event)
+	}
+}
+
+// Implemented by the parsers that can close the files opened while parsing
+type fileTracker interface {
+	track_file(fd *os.File)
+}
+
+func track_file(parser ArgumentParser, fd *os.File) {
+	if tracker, ok := parser.(fileTracker); ok {
+		tracker.track_file(fd)
+	}
+}
+
+// Implemented by the parsers that can return parsing errors to the caller
+type errorReturner interface {
+	returns_errors() bool
+}
+
+// Raised by parsing_error to abort a parse whose error is returned
+type abortedParsing struct {
+	err error
+}
+
+// Report an error to the event handler of the parser, then to OnParsingError,
+// or abort the parse if the error is to be returned by it
+func parsing_error(parser ArgumentParser, err error) {
+	emit_event(parser, Event{
+		Kind: EventError,
+		Err:  err,
+	})
+
+	if returner, ok := parser.(errorReturner); ok && returner.returns_errors() {
+		panic(abortedParsing{err})
+	}
+
+	OnParsingError(parser, err)
+}
+
+func (this *parser) returns_errors() bool {
+	return (this.continue_on_error && this.parsing) || this.parsing_map
+}
+
+// Deferred by the parsing functions, turns the error that aborted the parse
+// into the one returned to the caller
+func (this *parser) end_parsing(err *error) {
+	this.parsing = false
+	this.parsing_map = false
+
+	if r := recover(); r != nil {
+		aborted, ok := r.(abortedParsing)
+		if !ok {
+			panic(r)
+		}
+
+		*err = aborted.err
+	}
+}
+
+// Run a step of the parse, recording the error that aborts it instead of
+// aborting the whole parse, for all the errors to be returned at once
+func collect_error(errs *[]error, step func() error) {
+	defer func() {
+		if r := recover(); r != nil {
+			aborted, ok := r.(abortedParsing)
+			if !ok {
+				panic(r)
+			}
+
+			*errs = append(*errs, aborted.err)
+		}
+	}()
+
+	if err := step(); err != nil {
+		*errs = append(*errs, err)
+	}
+}
+
+// Join the errors of a parse, a single one being returned as is, e.g. ErrHelp
+func join_errors(errs []error) error {
+	var joined []error
+	for _, err := range errs {
+		if err != nil {
+			joined = append(joined, err)
+		}
+	}
+
+	if len(joined) == 1 {
+		return joined[0]
+	}
+
+	return errors.Join(joined...)
+}
+
+func (this *parser) emit(event Event) {
+	if this.event_handler != nil {
+		this.event_handler(event)
+	}
+}
+
+// Implemented by the parsers that write their messages to a custom output
+type outputWriter interface {
+	output_writer() io.Writer
+}
+
+func (this *parser) output_writer() io.Writer {
+	return this.output
+}
+
+func DefaultOnParsingErrorCallback(parser ArgumentParser, err error) {
+	var w io.Writer = os.Stdout
+	if writer, ok := parser.(outputWriter); ok {
+		w = writer.output_writer()
+	}
+
+	fmt.Fprintf(w, "%s\n", err.Error())
+	parser.PrintHelp()
+	os.Exit(1)
+}
+
+func (this *parser) IntVar(address interface{}, flag string, help string, options *IntVarOptions) error {
+	if accept, err := this.accept_registration(flag); !accept {
+		return err
+	}
+
+	if err := this.check_short_flag(flag, options.ShortFlag); err != nil {
+		return err
+	}
+
+	if err := check_placeholder[int](flag, address, true); err != nil {
+		return err
+	}
+
+	if options.Min != nil && options.Max != nil && *options.Min > *options.Max {
+		return fmt.Errorf("Empty range given for flag %s (%v > %v)", flag, *options.Min, *options.Max)
+	}
+
+	if options.NArgs == 0 {
+		options.NArgs = 1
+	}
+
+	v := &intVar{
+		baseVar: baseVar{
+			address: address,
+			flag:    flag,
+			help:    help,
+		},
+		options: *options,
+	}
+
+	if len(options.DefaultString) > 0 {
+		if err := this.apply_default_string(v, flag, options.DefaultString); err != nil {
+			return err
+		}
+	}
+
+	this.add_var(flag, v)
+
+	return nil
+}
+
+func (this *parser) FileVar(address interface{}, flag string, help string, options *FileVarOptions) error {
+	if accept, err := this.accept_registration(flag); !accept {
+		return err
+	}
+
+	if err := this.check_short_flag(flag, options.ShortFlag); err != nil {
+		return err
+	}
+
+	if options.Lazy {
+		if err := check_placeholder[*LazyFile](flag, address, false); err != nil {
+			return err
+		}
+	} else if err := check_placeholder[*os.File](flag, address, false); err != nil {
+		return err
+	}
+
+	if options.NArgs == 0 {
+		options.NArgs = 1
+	}
+
+	if options.CloseOnExit {
+		switch address.(type) {
+		case **os.File, *[]*os.File:
+		default:
+			return fmt.Errorf("Invalid address type passed")
+		}
+	}
+
+	this.add_var(flag, &fileVar{
+		baseVar: baseVar{
+			address: address,
+			flag:    flag,
+			help:    help,
+		},
+		options: *options,
+	})
+
+	return nil
+}
+
+func (this *parser) StringVar(address interface{}, flag string, help string, options *StringVarOptions) error {
+	if accept, err := this.accept_registration(flag); !accept {
+		return err
+	}
+
+	if err := this.check_short_flag(flag, options.ShortFlag); err != nil {
+		return err
+	}
+
+	if err := check_placeholder[string](flag, address, true); err != nil {
+		return err
+	}
+
+	if options.Uppercase && options.Lowercase {
+		return fmt.Errorf("Flag %s can't be both uppercased and lowercased", flag)
+	}
+
+	if _, isStringPtr := address.(*string); isStringPtr && !strings.HasPrefix(flag, "-") && options.NArgs > 1 {
+		return fmt.Errorf("Positional %s collects %d values and needs a slice placeholder", flag, options.NArgs)
+	}
+
+	// A flag that isn't a switch storing its ValueOnExist takes a value
+	if strings.HasPrefix(flag, "-") && options.NArgs == 0 && len(options.ValueOnExist) == 0 {
+		options.NArgs = 1
+	}
+
+	var pattern *regexp.Regexp
+	if len(options.Pattern) > 0 {
+		var err error
+
+		if pattern, err = regexp.Compile("^(?:" + options.Pattern + ")$"); err != nil {
+			return fmt.Errorf("Invalid pattern given for flag %s: %s", flag, err)
+		}
+	}
+
+	v := &stringVar{
+		baseVar: baseVar{
+			address: address,
+			flag:    flag,
+			help:    help,
+		},
+		options:      *options,
+		pattern:      pattern,
+		file_choices: new([]string),
+	}
+
+	if len(options.DefaultString) > 0 {
+		if err := this.apply_default_string(v, flag, options.DefaultString); err != nil {
+			return err
+		}
+	}
+
+	this.add_var(flag, v)
+
+	return nil
+}
 
 func (this *parser) BoolVar(address interface{}, flag string, help string, options *BoolVarOptions) error {
-	if _, ok := this.vars[flag]; ok == true {
-		return fmt.Errorf("Flag \"%s\" was already added to the parser", flag)
+	if accept, err := this.accept_registration(flag); !accept {
+		return err
+	}
+
+	if err := this.check_short_flag(flag, options.ShortFlag); err != nil {
+		return err
+	}
+
+	counted := options.Count || options.CountDown
+	if _, isIntPtr := address.(*int); counted && (!isIntPtr || options.NArgs != 0) {
+		return fmt.Errorf("Counted flag %s requires an *int placeholder and no parameters", flag)
+	} else if err := check_placeholder[bool](flag, address, true); err != nil && !counted {
+		return err
+	}
+
+	// A switch that defaults to false is set to true when given
+	if options.NArgs == 0 && !options.Default && !options.ValueOnExist {
+		options.ValueOnExist = true
+	}
+
+	v := &boolVar{
+		baseVar: baseVar{
+			address: address,
+			flag:    flag,
+			help:    help,
+		},
+		options: *options,
+	}
+
+	if len(options.DefaultString) > 0 {
+		if err := this.apply_default_string(v, flag, options.DefaultString); err != nil {
+			return err
+		}
+	}
+
+	this.add_var(flag, v)
+
+	return nil
+}
+
+func (this *parser) PathVar(address interface{}, flag string, help string, options *PathVarOptions) error {
+	if accept, err := this.accept_registration(flag); !accept {
+		return err
+	}
+
+	if err := this.check_short_flag(flag, options.ShortFlag); err != nil {
+		return err
+	}
+
+	if err := check_placeholder[string](flag, address, false); err != nil {
+		return err
+	}
+
+	if options.NArgs == 0 {
+		options.NArgs = 1
+	}
+
+	v := &pathVar{
+		baseVar: baseVar{
+			address: address,
+			flag:    flag,
+			help:    help,
+		},
+		options: *options,
+	}
+
+	if len(options.DefaultString) > 0 {
+		if err := this.apply_default_string(v, flag, options.DefaultString); err != nil {
+			return err
+		}
+	}
+
+	this.add_var(flag, v)
+
+	return nil
+}
+
+func (this *parser) FloatVar(address interface{}, flag string, help string, options *FloatVarOptions) error {
+	if accept, err := this.accept_registration(flag); !accept {
+		return err
+	}
+
+	if err := this.check_short_flag(flag, options.ShortFlag); err != nil {
+		return err
+	}
+
+	if err := check_placeholder[float64](flag, address, true); err != nil {
+		return err
+	}
+
+	if options.Min != nil && options.Max != nil && *options.Min > *options.Max {
+		return fmt.Errorf("Empty range given for flag %s (%v > %v)", flag, *options.Min, *options.Max)
+	}
+
+	if options.NArgs == 0 {
+		options.NArgs = 1
+	}
+
+	v := &floatVar{
+		baseVar: baseVar{
+			address: address,
+			flag:    flag,
+			help:    help,
+		},
+		options: *options,
+	}
+
+	if len(options.DefaultString) > 0 {
+		if err := this.apply_default_string(v, flag, options.DefaultString); err != nil {
+			return err
+		}
+	}
+
+	this.add_var(flag, v)
+
+	return nil
+}
+
+func (this *parser) Int64Var(address interface{}, flag string, help string, options *Int64VarOptions) error {
+	if accept, err := this.accept_registration(flag); !accept {
+		return err
+	}
+
+	if err := this.check_short_flag(flag, options.ShortFlag); err != nil {
+		return err
+	}
+
+	if err := check_placeholder[int64](flag, address, true); err != nil {
+		return err
+	}
+
+	if options.NArgs == 0 {
+		options.NArgs = 1
+	}
+
+	v := &int64Var{
+		baseVar: baseVar{
+			address: address,
+			flag:    flag,
+			help:    help,
+		},
+		options: *options,
+	}
+
+	if len(options.DefaultString) > 0 {
+		if err := this.apply_default_string(v, flag, options.DefaultString); err != nil {
+			return err
+		}
+	}
+
+	this.add_var(flag, v)
+
+	return nil
+}
+
+func (this *parser) UintVar(address interface{}, flag string, help string, options *UintVarOptions) error {
+	if accept, err := this.accept_registration(flag); !accept {
+		return err
+	}
+
+	if err := this.check_short_flag(flag, options.ShortFlag); err != nil {
+		return err
+	}
+
+	if err := check_placeholder[uint64](flag, address, true); err != nil {
+		return err
+	}
+
+	if options.NArgs == 0 {
+		options.NArgs = 1
+	}
+
+	v := &uintVar{
+		baseVar: baseVar{
+			address: address,
+			flag:    flag,
+			help:    help,
+		},
+		options: *options,
+	}
+
+	if len(options.DefaultString) > 0 {
+		if err := this.apply_default_string(v, flag, options.DefaultString); err != nil {
+			return err
+		}
+	}
+
+	this.add_var(flag, v)
+
+	return nil
+}
+
+func (this *parser) PercentVar(address *float64, flag string, help string, options *PercentVarOptions) error {
+	if accept, err := this.accept_registration(flag); !accept {
+		return err
+	}
+
+	if err := this.check_short_flag(flag, options.ShortFlag); err != nil {
+		return err
+	}
+
+	if options.NArgs == 0 {
+		options.NArgs = 1
+	}
+
+	v := &percentVar{
+		baseVar: baseVar{
+			address: address,
+			flag:    flag,
+			help:    help,
+		},
+		options: *options,
+	}
+
+	if len(options.DefaultString) > 0 {
+		if err := this.apply_default_string(v, flag, options.DefaultString); err != nil {
+			return err
+		}
+	}
+
+	this.add_var(flag, v)
+
+	return nil
+}
+
+func (this *parser) DurationVar(address interface{}, flag string, help string, options *DurationVarOptions) error {
+	if accept, err := this.accept_registration(flag); !accept {
+		return err
+	}
+
+	if err := this.check_short_flag(flag, options.ShortFlag); err != nil {
+		return err
+	}
+
+	if err := check_placeholder[time.Duration](flag, address, true); err != nil {
+		return err
+	}
+
+	if options.NArgs == 0 {
+		options.NArgs = 1
+	}
+
+	v := &durationVar{
+		baseVar: baseVar{
+			address: address,
+			flag:    flag,
+			help:    help,
+		},
+		options: *options,
+	}
+
+	if len(options.DefaultString) > 0 {
+		if err := this.apply_default_string(v, flag, options.DefaultString); err != nil {
+			return err
+		}
+	}
+
+	this.add_var(flag, v)
+
+	return nil
+}
+
+func (this *parser) EnumVar(address interface{}, flag string, help string, options *EnumVarOptions) error {
+	if accept, err := this.accept_registration(flag); !accept {
+		return err
+	}
+
+	if err := this.check_short_flag(flag, options.ShortFlag); err != nil {
+		return err
+	}
+
+	if err := check_placeholder[int](flag, address, true); err != nil {
+		return err
+	}
+
+	if len(options.Values) == 0 {
+		return fmt.Errorf("No values given for enum flag %s", flag)
+	} else if _, ok := options.Values[options.Default]; len(options.Default) > 0 && !ok {
+		return fmt.Errorf("Default value of enum flag %s is not one of its values (got %s)", flag, options.Default)
+	}
+
+	if options.NArgs == 0 {
+		options.NArgs = 1
+	}
+
+	this.add_var(flag, &enumVar{
+		baseVar: baseVar{
+			address: address,
+			flag:    flag,
+			help:    help,
+		},
+		options: *options,
+	})
+
+	return nil
+}
+
+func (this *parser) Var(value Value, flag string, help string, options *VarOptions) error {
+	if accept, err := this.accept_registration(flag); !accept {
+		return err
+	}
+
+	if err := this.check_short_flag(flag, options.ShortFlag); err != nil {
+		return err
+	}
+
+	if value == nil {
+		return fmt.Errorf("No value given for flag %s", flag)
+	}
+
+	if options.NArgs == 0 && !is_bool_value(value) {
+		options.NArgs = 1
+	}
+
+	this.add_var(flag, &valueVar{
+		baseVar: baseVar{
+			address: value,
+			flag:    flag,
+			help:    help,
+		},
+		options: *options,
+	})
+
+	return nil
+}
+
+func (this *parser) RangeListVar(address *[]int, flag string, help string, options *RangeListVarOptions) error {
+	if accept, err := this.accept_registration(flag); !accept {
+		return err
+	}
+
+	if err := this.check_short_flag(flag, options.ShortFlag); err != nil {
+		return err
+	}
+
+	if options.NArgs == 0 {
+		options.NArgs = 1
+	}
+
+	this.add_var(flag, &rangeListVar{
+		baseVar: baseVar{
+			address: address,
+			flag:    flag,
+			help:    help,
+		},
+		options: *options,
+	})
+
+	return nil
+}
+
+func (this *parser) SetOverrideVar(address *map[string]interface{}, flag string, help string) error {
+	if accept, err := this.accept_registration(flag); !accept {
+		return err
+	}
+
+	this.add_var(flag, &overrideVar{
+		baseVar: baseVar{
+			address: address,
+			flag:    flag,
+			help:    help,
+		},
+	})
+
+	return nil
+}
+
+func (this *parser) MustIntVar(address interface{}, flag string, help string, options *IntVarOptions) {
+	must(this.IntVar(address, flag, help, options))
+}
+
+func (this *parser) MustFileVar(address interface{}, flag string, help string, options *FileVarOptions) {
+	must(this.FileVar(address, flag, help, options))
+}
+
+func (this *parser) MustStringVar(address interface{}, flag string, help string, options *StringVarOptions) {
+	must(this.StringVar(address, flag, help, options))
+}
+
+func (this *parser) MustBoolVar(address interface{}, flag string, help string, options *BoolVarOptions) {
+	must(this.BoolVar(address, flag, help, options))
+}
+
+func (this *parser) MustPathVar(address interface{}, flag string, help string, options *PathVarOptions) {
+	must(this.PathVar(address, flag, help, options))
+}
+
+func (this *parser) MustFloatVar(address interface{}, flag string, help string, options *FloatVarOptions) {
+	must(this.FloatVar(address, flag, help, options))
+}
+
+func (this *parser) MustInt64Var(address interface{}, flag string, help string, options *Int64VarOptions) {
+	must(this.Int64Var(address, flag, help, options))
+}
+
+func (this *parser) MustUintVar(address interface{}, flag string, help string, options *UintVarOptions) {
+	must(this.UintVar(address, flag, help, options))
+}
+
+func (this *parser) MustPercentVar(address *float64, flag string, help string, options *PercentVarOptions) {
+	must(this.PercentVar(address, flag, help, options))
+}
+
+func (this *parser) MustDurationVar(address interface{}, flag string, help string, options *DurationVarOptions) {
+	must(this.DurationVar(address, flag, help, options))
+}
+
+func (this *parser) MustEnumVar(address interface{}, flag string, help string, options *EnumVarOptions) {
+	must(this.EnumVar(address, flag, help, options))
+}
+
+func (this *parser) MustVar(value Value, flag string, help string, options *VarOptions) {
+	must(this.Var(value, flag, help, options))
+}
+
+func (this *parser) MustRangeListVar(address *[]int, flag string, help string, options *RangeListVarOptions) {
+	must(this.RangeListVar(address, flag, help, options))
+}
+
+func (this *parser) MustSetOverrideVar(address *map[string]interface{}, flag string, help string) {
+	must(this.SetOverrideVar(address, flag, help))
+}
+
+// Register a flag from a terse description, e.g. "-n,--count int required
+// default=1 help='the count'", and return a pointer to its value
+func (this *parser) Spec(spec string) (interface{}, error) {
+	return register_spec(this, spec)
+}
+
+func (this *parser) WithPrefix(prefix string) ArgumentParser {
+	return &prefixedParser{
+		parser: this,
+		prefix: prefix,
+	}
+}
+
+func (this *parser) Parse(args []string) (remaining []string, err error) {
+	this.parsing = true
+	defer this.end_parsing(&err)
+
+	if err := this.report_refused_changes(); err != nil {
+		return nil, err
+	}
+
+	args = expand_response_files(this, args, 0)
+
+	if len(args) == 0 {
+		if err := this.print_help_on_empty(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Nothing is parsed from a stop token on, it is returned with the arguments
+	// that follow it
+	var stopped_args []string
+	if idx := find_token(this.vars, args, this.stop_tokens); idx > -1 {
+		args, stopped_args = args[:idx], args[idx:]
+	}
+
+	// The arguments following a subcommand, "--" included, are handed to the
+	// parser of the subcommand
+	var subcommand_args []string
+	this.reset_parse_state()
+	if idx := this.find_subcommand(args); idx > -1 && this.is_help_command(args[idx]) {
+		return nil, this.print_subcommand_help(args[idx+1:])
+	} else if idx > -1 {
+		this.selected_subcommand, _ = this.lookup_subcommand(args[idx])
+		args, subcommand_args = args[:idx], args[idx+1:]
+	}
+
+	// Everything after a bare "--" is only ever handed to the positionals
+	for i, arg := range args {
+		if arg == "--" {
+			this.passthrough = append([]string{}, args[i+1:]...)
+			args = args[:i]
+			break
+		}
+	}
+
+	// We check for the -h/--help flags before processing the arguments, so that
+	// missing required flags don't prevent the help from being printed, and
+	// skip the values of the flags not to trigger a false positive if those
+	// strings are passed as flag arguments
+	help_tokens, version_tokens := this.help_and_version_tokens()
+	if idx := find_token(this.vars, args, append(help_tokens, version_tokens...)); idx > -1 {
+		return nil, this.print_help_or_version(args[idx], help_tokens)
+	}
+
+	// The errors of all the flags are returned at once
+	unparsed_args, err := parse_flags(this, this.vars, this.order, args, this.report_all_missing, this.negation_prefixes, this.strict_values, this.sources, this.spellings)
+	errs := []error{err}
+
+	remaining, err = this.parse_leftovers(unparsed_args, err != nil)
+	errs = append(errs, err)
+
+	if len(this.selected_subcommand) > 0 {
+		subcommand := this.subcommands[this.selected_subcommand]
+		this.pass_settings(subcommand)
+
+		subcommand_remaining, err := subcommand.Parse(subcommand_args)
+		errs = append(errs, err)
+		remaining = append(remaining, subcommand_remaining...)
+	}
+
+	if err := join_errors(errs); err != nil {
+		return nil, err
+	}
+
+	return append(remaining, stopped_args...), nil
+}
+
+// Print the help when no arguments are given to a parser that has required
+// flags, if it was asked to
+func (this *parser) print_help_on_empty() error {
+	if !this.help_on_empty {
+		return nil
+	}
+
+	for _, addr := range this.vars {
+		Required := false
+
+		if err := extract_base_options(addr, new(string), &Required, new(int), new(bool), new(bool)); err != nil {
+			return err
+		}
+
+		if Required {
+			this.PrintHelp()
+			if this.continue_on_error {
+				return ErrHelp
+			}
+			os.Exit(0)
+		}
+	}
+
+	return nil
+}
+
+// Forget what the previous parse gathered
+func (this *parser) reset_parse_state() {
+	this.selected_subcommand = ""
+	this.sources = make(map[string]string)
+	this.spellings = make(map[string]string)
+	this.warnings = nil
+	this.passthrough = nil
+}
+
+// Return the help and version flags that aren't shadowed by registered flags
+func (this *parser) help_and_version_tokens() (help_tokens []string, version_tokens []string) {
+	for _, token := range []string{this.help_short_flag, this.help_long_flag} {
+		if len(token) > 0 && !is_registered_flag(this.vars, token) {
+			help_tokens = append(help_tokens, token)
+		}
+	}
+	if len(this.version) > 0 {
+		for _, token := range []string{this.version_short_flag, this.version_long_flag} {
+			if len(token) > 0 && !is_registered_flag(this.vars, token) {
+				version_tokens = append(version_tokens, token)
+			}
+		}
+	}
+
+	return help_tokens, version_tokens
+}
+
+// Print the help or the version requested by the given token, and exit
+// unless errors are returned
+func (this *parser) print_help_or_version(token string, help_tokens []string) error {
+	if value_in_choices(token, help_tokens) {
+		this.PrintHelp()
+		if this.continue_on_error {
+			return ErrHelp
+		}
+	} else {
+		fmt.Fprintf(this.output, "%s %s\n", this.prog, this.version)
+		if this.continue_on_error {
+			return ErrVersion
+		}
+	}
+	os.Exit(0)
+
+	return nil
+}
+
+// Check the exclusive groups once the flags are parsed, and hand the
+// arguments they didn't consume to the positionals, unless some flags failed to
+// be parsed and left their own among them
+func (this *parser) parse_leftovers(unparsed_args []string, flags_failed bool) (remaining []string, err error) {
+	var errs []error
+
+	for _, group := range this.exclusive_groups {
+		collect_error(&errs, func() error {
+			var given []string
+			for _, flag := range group.flags {
+				if _, ok := this.sources[flag]; ok {
+					given = append(given, flag)
+				}
+			}
+
+			if len(given) > 1 {
+				parsing_error(this, fmt.Errorf("Flags %s are mutually exclusive", strings.Join(given, ", ")))
+			} else if len(given) == 0 && group.required {
+				parsing_error(this, fmt.Errorf("One of the flags %s is required", strings.Join(group.flags, ", ")))
+			}
+
+			return nil
+		})
+	}
+
+	if flags_failed {
+		return nil, join_errors(errs)
+	}
+
+	collect_error(&errs, func() error {
+		// Only the unknown flags are left over when positionals are disabled
+		if this.disable_positionals {
+			for _, arg := range unparsed_args {
+				if !strings.HasPrefix(arg, "-") || arg == "-" || is_negative_number(arg) {
+					parsing_error(this, fmt.Errorf("Unexpected positional argument %s", arg))
+				}
+			}
+			if len(this.passthrough) > 0 {
+				parsing_error(this, fmt.Errorf("Unexpected positional argument %s", this.passthrough[0]))
+			}
+
+			remaining = unparsed_args
+			return nil
+		}
+
+		positional_args := append(append([]string{}, unparsed_args...), this.passthrough...)
+		remaining, err = parse_positionals(this, this.vars, this.order, positional_args, this.sources)
+
+		return err
+	})
+
+	return remaining, join_errors(errs)
+}
+
+// Return the index of the first of the given tokens that isn't the value of a
+// flag, or -1 if there is none before "--"
+func find_token(vars map[string]interface{}, args []string, tokens []string) int {
+	if len(tokens) == 0 {
+		return -1
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if arg == "--" {
+			return -1
+		} else if value_in_choices(arg, tokens) {
+			return i
+		} else if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+
+		if nargs := flag_nargs(vars, arg); nargs < 0 {
+			i += count_variadic_values(args[i+1:])
+		} else {
+			i += nargs
+		}
+	}
+
+	return -1
+}
+
+// Maximum depth of the response files referenced by other response files
+const MaxResponseFileDepth = 10
+
+// Replace the arguments of the form @path with the whitespace separated
+// contents of the file at path, which can reference other response files
+func expand_response_files(parser ArgumentParser, args []string, depth int) []string {
+	var expanded []string
+
+	for i, arg := range args {
+		if arg == "--" {
+			return append(expanded, args[i:]...)
+		} else if !strings.HasPrefix(arg, "@") || len(arg) == 1 {
+			expanded = append(expanded, arg)
+			continue
+		}
+
+		if depth >= MaxResponseFileDepth {
+			parsing_error(parser, fmt.Errorf("Too many nested response files (more than %d) at %s", MaxResponseFileDepth, arg))
+			return nil
+		}
+
+		contents, err := os.ReadFile(arg[1:])
+		if err != nil {
+			parsing_error(parser, fmt.Errorf("Unable to read the response file %s: %s", arg[1:], err))
+			continue
+		}
+
+		expanded = append(expanded, expand_response_files(parser, strings.Fields(string(contents)), depth+1)...)
+	}
+
+	return expanded
+}
+
+// Return the number of arguments consumed by the given flag when they aren't
+// assigned with "=", 0 for the arguments that aren't registered flags
+func flag_nargs(vars map[string]interface{}, arg string) int {
+	for flag, addr := range vars {
+		ShortFlag := ""
+		NArgs := 0
+
+		if err := extract_base_options(addr, &ShortFlag, new(bool), &NArgs, new(bool), new(bool)); err != nil {
+			continue
+		}
+
+		if strings.HasPrefix(flag, "-") && (arg == flag || (len(ShortFlag) > 0 && arg == ShortFlag)) {
+			return NArgs
+		}
+	}
+
+	return 0
+}
+
+// Return the index of the first argument that is neither a flag nor the value
+// of one if it names a subcommand, -1 otherwise
+func (this *parser) find_subcommand(args []string) int {
+	if len(this.subcommands) == 0 {
+		return -1
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if arg == "--" {
+			return -1
+		} else if !strings.HasPrefix(arg, "-") || arg == "-" || is_negative_number(arg) {
+			if _, ok := this.lookup_subcommand(arg); ok || this.is_help_command(arg) {
+				return i
+			}
+			return -1
+		}
+
+		if nargs := flag_nargs(this.vars, arg); nargs < 0 {
+			i += count_variadic_values(args[i+1:])
+		} else {
+			i += nargs
+		}
+	}
+
+	return -1
+}
+
+// Tokens read one per line, the ones that were looked ahead at or read from a
+// response file being queued before those left in the stream
+type tokenStream struct {
+	scanner *bufio.Scanner
+	queued  []string
+}
+
+func (this *tokenStream) next() (string, bool) {
+	if len(this.queued) > 0 {
+		token := this.queued[0]
+		this.queued = this.queued[1:]
+		return token, true
+	}
+
+	if this.scanner.Scan() {
+		return this.scanner.Text(), true
+	}
+
+	return "", false
+}
+
+func (this *tokenStream) push(tokens ...string) {
+	this.queued = append(append([]string{}, tokens...), this.queued...)
+}
+
+// Parse the tokens as they are read: each flag is parsed with the values that
+// follow it, in the order they are given, and only the arguments left to the
+// positionals are kept in memory
+func (this *parser) ParseStream(r io.Reader) ([]string, error) {
+	stream := &tokenStream{scanner: bufio.NewScanner(r)}
+
+	remaining, err := this.parse_stream(stream)
+	if scan_err := stream.scanner.Err(); scan_err != nil {
+		return nil, scan_err
+	}
+
+	return remaining, err
+}
+
+// Tell whether a token read from a stream is an argument left to the
+// positionals, and not a flag or a token the stream stops at
+func (this *parser) is_stream_positional(token string, positional_given bool) bool {
+	if _, ok := this.lookup_subcommand(token); (ok || this.is_help_command(token)) && !positional_given {
+		return false
+	}
+
+	return token != "--" && !value_in_choices(token, this.stop_tokens) && count_variadic_values([]string{token}) > 0
+}
+
+func (this *parser) parse_stream(stream *tokenStream) (remaining []string, err error) {
+	this.parsing = true
+	defer this.end_parsing(&err)
+
+	if err := this.report_refused_changes(); err != nil {
+		return nil, err
+	}
+
+	// Response files are expanded as they are met, up to a bare "--"
+	next := func() (string, bool) {
+		for {
+			token, ok := stream.next()
+			if !ok || !strings.HasPrefix(token, "@") || len(token) == 1 {
+				return token, ok
+			}
+
+			stream.push(expand_response_files(this, []string{token}, 0)...)
+		}
+	}
+
+	token, ok := next()
+	if !ok {
+		if err := this.print_help_on_empty(); err != nil {
+			return nil, err
+		}
+	}
+
+	this.reset_parse_state()
+	help_tokens, version_tokens := this.help_and_version_tokens()
+	lengths := make(map[string]int)
+	for _, flag := range this.order {
+		lengths[flag] = slice_placeholder_length(this.vars[flag])
+	}
+
+	var unparsed_args, stopped_args []string
+	var errs []error
+	// Subcommands are only looked for up to the first positional
+	positional_given := false
+	for ; ok; token, ok = next() {
+		if token == "--" {
+			for token, ok = stream.next(); ok; token, ok = stream.next() {
+				this.passthrough = append(this.passthrough, token)
+			}
+			break
+		} else if value_in_choices(token, this.stop_tokens) {
+			stopped_args = append(stopped_args, token)
+			for token, ok = stream.next(); ok; token, ok = stream.next() {
+				stopped_args = append(stopped_args, token)
+			}
+			break
+		} else if value_in_choices(token, help_tokens) || value_in_choices(token, version_tokens) {
+			return nil, this.print_help_or_version(token, help_tokens)
+		} else if this.is_help_command(token) && !positional_given {
+			var help_args []string
+			for token, ok = stream.next(); ok; token, ok = stream.next() {
+				help_args = append(help_args, token)
+			}
+			return nil, this.print_subcommand_help(help_args)
+		} else if name, ok := this.lookup_subcommand(token); ok && !positional_given {
+			this.selected_subcommand = name
+			break
+		} else if count_variadic_values([]string{token}) > 0 {
+			positional_given = true
+			unparsed_args = append(unparsed_args, token)
+			continue
+		}
+
+		// The flag is parsed with the values it takes, and the positional that
+		// follows them for the strict values to be enforced
+		args := []string{token}
+		nargs := flag_nargs(this.vars, token)
+		for len(args) <= nargs || nargs < 0 {
+			value, ok := next()
+			if !ok {
+				break
+			} else if value == "--" || (nargs < 0 && count_variadic_values([]string{value}) == 0) {
+				stream.push(value)
+				break
+			}
+
+			args = append(args, value)
+		}
+		if this.strict_values {
+			if value, ok := next(); ok && this.is_stream_positional(value, positional_given) {
+				positional_given = true
+				args = append(args, value)
+			} else if ok {
+				stream.push(value)
+			}
+		}
+
+		collect_error(&errs, func() error {
+			for _, flag := range this.order {
+				if !strings.HasPrefix(flag, "-") {
+					continue
+				}
+
+				var err error
+				if args, _, err = consume_flag(this, this.vars, flag, args, this.negation_prefixes, this.strict_values, this.sources, this.spellings); err != nil {
+					return err
+				}
+			}
+			unparsed_args = append(unparsed_args, args...)
+
+			return nil
+		})
+	}
+
+	if this.report_all_missing {
+		collect_error(&errs, func() error {
+			missing, err := find_missing_required_flags(this.vars, this.order, nil)
+			if err != nil {
+				return err
+			}
+
+			var absent []string
+			for _, flag := range missing {
+				if _, ok := this.sources[strings.Split(flag, "/")[0]]; !ok {
+					absent = append(absent, flag)
+				}
+			}
+			if len(absent) > 0 {
+				parsing_error(this, fmt.Errorf("Missing required flags: %s", strings.Join(absent, ", ")))
+			}
+
+			return nil
+		})
+	}
+
+	for _, flag := range this.order {
+		if !strings.HasPrefix(flag, "-") {
+			continue
+		}
+
+		collect_error(&errs, func() error {
+			_, found := this.sources[flag]
+			return check_flag_presence(this, flag, this.vars[flag], found, lengths[flag], this.report_all_missing, this.sources)
+		})
+	}
+
+	remaining, err = this.parse_leftovers(unparsed_args, len(errs) > 0)
+	errs = append(errs, err)
+
+	if len(this.selected_subcommand) > 0 {
+		subcommand := this.subcommands[this.selected_subcommand]
+		this.pass_settings(subcommand)
+
+		subcommand_remaining, err := subcommand.parse_stream(stream)
+		errs = append(errs, err)
+		remaining = append(remaining, subcommand_remaining...)
+	}
+
+	if err := join_errors(errs); err != nil {
+		return nil, err
+	}
+
+	return append(remaining, stopped_args...), nil
+}
+
+func (this *parser) ParseRich(args []string) (*ParseResult, error) {
+	remaining, err := this.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ParseResult{
+		Remaining:   remaining,
+		Passthrough: this.passthrough,
+		Sources:     this.sources,
+		Warnings:    this.warnings,
+	}, nil
+}
+
+func (this *parser) SetHelpFlags(short_flag, long_flag string) {
+	if err := this.check_unsealed(); err != nil {
+		this.refuse_change(err)
+		return
+	}
+
+	this.help_short_flag = short_flag
+	this.help_long_flag = long_flag
+}
+
+// Set the flags that print the version given to AddStandardFlags, either can
+// be empty
+func (this *parser) SetVersionFlags(short_flag, long_flag string) {
+	if err := this.check_unsealed(); err != nil {
+		this.refuse_change(err)
+		return
+	}
+
+	this.version_short_flag = short_flag
+	this.version_long_flag = long_flag
+}
+
+func (this *parser) SetReportAllMissing(enabled bool) {
+	if err := this.check_unsealed(); err != nil {
+		this.refuse_change(err)
+		return
+	}
+
+	this.report_all_missing = enabled
+}
+
+func (this *parser) SetOutput(w io.Writer) {
+	if err := this.check_unsealed(); err != nil {
+		this.refuse_change(err)
+		return
+	}
+
+	this.output = w
+}
+
+func (this *parser) SetWarningWriter(w io.Writer) {
+	if err := this.check_unsealed(); err != nil {
+		this.refuse_change(err)
+		return
+	}
+
+	this.warning_writer = w
+}
+
+func (this *parser) ParseMap(values map[string]string) (err error) {
+	this.parsing = true
+	this.parsing_map = true
+	defer this.end_parsing(&err)
+
+	if err := this.report_refused_changes(); err != nil {
+		return err
+	}
+
+	var flags []string
+
+	for flag := range values {
+		flags = append(flags, flag)
+	}
+	sort.Strings(flags)
+
+	for _, flag := range flags {
+		addr, ok := this.vars[flag]
+		if !ok {
+			return fmt.Errorf("Unknown flag \"%s\"", flag)
+		}
+
+		if _, err := consume_args(this, []string{flag, values[flag]}, 0, single_value_var(addr)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (this *parser) HelpOnEmpty(enabled bool) {
+	if err := this.check_unsealed(); err != nil {
+		this.refuse_change(err)
+		return
+	}
+
+	this.help_on_empty = enabled
+}
+
+func (this *parser) SetHelpSort(mode HelpSort) {
+	if err := this.check_unsealed(); err != nil {
+		this.refuse_change(err)
+		return
+	}
+
+	this.help_sort = mode
+}
+
+func (this *parser) SetDuplicatePolicy(policy DuplicatePolicy) {
+	if err := this.check_unsealed(); err != nil {
+		this.refuse_change(err)
+		return
+	}
+
+	this.duplicate_policy = policy
+}
+
+func (this *parser) SetNegationPrefixes(prefixes ...string) {
+	if err := this.check_unsealed(); err != nil {
+		this.refuse_change(err)
+		return
+	}
+
+	this.negation_prefixes = prefixes
+}
+
+func (this *parser) SetStrictValues(enabled bool) {
+	if err := this.check_unsealed(); err != nil {
+		this.refuse_change(err)
+		return
+	}
+
+	this.strict_values = enabled
+}
+
+func (this *parser) add_exclusive_group(flags []string, required bool) error {
+	if err := this.check_unsealed(); err != nil {
+		return err
+	}
+
+	if len(flags) < 2 {
+		return fmt.Errorf("A group of mutually exclusive flags needs at least two flags")
+	}
+
+	for _, flag := range flags {
+		if _, ok := this.vars[flag]; !ok || !strings.HasPrefix(flag, "-") {
+			return fmt.Errorf("Unknown flag \"%s\" in a group of mutually exclusive flags", flag)
+		}
+	}
+
+	this.exclusive_groups = append(this.exclusive_groups, exclusiveGroup{
+		flags:    append([]string{}, flags...),
+		required: required,
+	})
+
+	return nil
+}
+
+// Allow at most one of the given flags to be given
+func (this *parser) MutuallyExclusive(flags ...string) error {
+	return this.add_exclusive_group(flags, false)
+}
+
+// Require exactly one of the given flags to be given
+func (this *parser) MutuallyExclusiveRequired(flags ...string) error {
+	return this.add_exclusive_group(flags, true)
+}
+
+// Stop parsing at any of the given tokens, which is returned verbatim along
+// with all the arguments that follow it
+func (this *parser) SetStopTokens(tokens ...string) {
+	if err := this.check_unsealed(); err != nil {
+		this.refuse_change(err)
+		return
+	}
+
+	this.stop_tokens = tokens
+}
+
+// Reject the arguments that are not flags, instead of handing them to the
+// positionals
+func (this *parser) DisablePositionals(disabled bool) {
+	if err := this.check_unsealed(); err != nil {
+		this.refuse_change(err)
+		return
 	}
 
-	this.vars[flag] = &boolVar{
-		baseVar: baseVar{
-			address: address,
-			flag:    flag,
-			help:    help,
-		},
-		options: *options,
+	this.disable_positionals = disabled
+}
+
+// Return parsing errors from the Parse functions instead of passing them to
+// OnParsingError, all the flags being parsed before they are returned at
+// once, and never exit the process
+func (this *parser) SetContinueOnError(enabled bool) {
+	if err := this.check_unsealed(); err != nil {
+		this.refuse_change(err)
+		return
+	}
+
+	this.continue_on_error = enabled
+}
+
+func (this *parser) SetEventHandler(handler func(Event)) {
+	if err := this.check_unsealed(); err != nil {
+		this.refuse_change(err)
+		return
+	}
+
+	this.event_handler = handler
+}
+
+func (this *parser) AddStandardFlags(options StandardFlagsOptions) error {
+	return add_standard_flags(this, this, options)
+}
+
+// Configure the version of a parser, and register the standard flags through
+// the given one, which can be a prefixed facade of it
+func add_standard_flags(base *parser, registrar ArgumentParser, options StandardFlagsOptions) error {
+	if err := base.check_unsealed(); err != nil {
+		return err
+	}
+
+	base.version = options.Version
+
+	if options.Verbose != nil {
+		if err := registrar.BoolVar(options.Verbose, "--verbose", "Print more information", &BoolVarOptions{
+			ShortFlag:    "-v",
+			ValueOnExist: true,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if options.Quiet != nil {
+		if err := registrar.BoolVar(options.Quiet, "--quiet", "Print less information", &BoolVarOptions{
+			ShortFlag:    "-q",
+			ValueOnExist: true,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if options.Config != nil {
+		if err := registrar.PathVar(options.Config, "--config", "Path to the configuration file", &PathVarOptions{
+			ShortFlag: "-c",
+		}); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (this *parser) Parse(args []string) ([]string, error) {
-	unparsed_args, err := parse_flags(this, this.vars, args)
+func (this *parser) AddVerbosity(address *int) error {
+	return add_verbosity(this, address)
+}
+
+// Register -v/--verbose and -q/--quiet through the given parser, which
+// respectively increment and decrement the same level of verbosity
+func add_verbosity(registrar ArgumentParser, address *int) error {
+	if address == nil {
+		return fmt.Errorf("No placeholder given for the level of verbosity")
+	}
+
+	if err := registrar.BoolVar(address, "--verbose", "Print more information", &BoolVarOptions{
+		ShortFlag: "-v",
+		Count:     true,
+	}); err != nil {
+		return err
+	}
+
+	return registrar.BoolVar(address, "--quiet", "Print less information", &BoolVarOptions{
+		ShortFlag: "-q",
+		CountDown: true,
+	})
+}
+
+// Write the values of all the flags and positionals to a file as a JSON
+// object, along with where they came from
+func (this *parser) DumpResolved(path string) error {
+	type resolvedFlag struct {
+		Value  interface{} `json:"value"`
+		Source string      `json:"source,omitempty"`
+	}
+
+	resolved := make(map[string]resolvedFlag)
+	for _, flag := range this.order {
+		if placeholder_address(this.vars[flag]) == nil {
+			continue
+		}
+
+		resolved[flag] = resolvedFlag{
+			Value:  json_value(this.vars[flag]),
+			Source: this.sources[flag],
+		}
+	}
+
+	data, err := json.MarshalIndent(resolved, "", "  ")
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// We check for the -h/--help flags after processing the arguments in order
-	// not to trigger a false positive if those strings are passed as flag
-	// arguments
-	// TODO: implement --
-	if idx := math.Min(float64(sort.SearchStrings(unparsed_args, HelpShortFlag)), float64(sort.SearchStrings(unparsed_args, HelpLongFlag))); int(idx) < len(args) {
-		this.PrintHelp()
-		os.Exit(0)
+	return os.WriteFile(path, append(data, '\n'), 0640)
+}
+
+// Set an environment variable for every flag and positional, named after it
+// with the given prefix, to its current value, slices are joined with commas
+func (this *parser) ExportEnv(prefix string) error {
+	var errs []error
+
+	for _, flag := range this.order {
+		// Absent pointers to pointers and empty slices are not exported
+		values := format_placeholder(placeholder_address(this.vars[flag]))
+		if len(values) == 0 {
+			continue
+		}
+
+		if err := os.Setenv(env_var_name(prefix, flag), strings.Join(values, ",")); err != nil {
+			errs = append(errs, fmt.Errorf("Unable to export flag %s: %s", flag, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Check the values currently held by the placeholders of the flags set by the
+// last parse, or given a default, against the constraints of their flags,
+// without parsing any argument, and return all the violations found
+func (this *parser) RevalidateValues() error {
+	var errs []error
+
+	for _, flag := range this.order {
+		addr := this.vars[flag]
+
+		// The placeholder of a flag that was neither set nor given a default
+		// holds no value to check
+		if _, set := this.sources[flag]; !set && len(extract_default_value(addr)) == 0 {
+			continue
+		}
+
+		errs = append(errs, revalidate_var(addr)...)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Register a subcommand, whose parser is handed the arguments that follow its
+// name, e.g. the flags of "add" in "tool --verbose add --force"
+func (this *parser) Subcommand(name string, description string) ArgumentParser {
+	if subcommand, ok := this.subcommands[name]; ok {
+		return subcommand
+	}
+
+	// The subcommand of a sealed parser is never dispatched to, and is sealed
+	// itself so that its configuration is refused too
+	if err := this.check_unsealed(); err != nil {
+		this.refuse_change(err)
+
+		subcommand := NewArgumentsParser(this.prog+" "+name, description).(*parser)
+		subcommand.Seal()
+		return subcommand
+	}
+
+	subcommand := NewArgumentsParser(this.prog+" "+name, description).(*parser)
+	this.pass_settings(subcommand)
+
+	if this.subcommands == nil {
+		this.subcommands = make(map[string]*parser)
+	}
+	this.subcommands[name] = subcommand
+	this.subcommand_order = append(this.subcommand_order, name)
+
+	return subcommand
+}
+
+// Make an alias dispatch to a registered subcommand, e.g. ci to commit
+func (this *parser) AddSubcommandAlias(alias string, target string) error {
+	if err := this.check_unsealed(); err != nil {
+		return err
+	}
+
+	if _, ok := this.subcommands[target]; !ok {
+		return fmt.Errorf("No subcommand %s to alias", target)
+	} else if _, ok := this.lookup_subcommand(alias); ok {
+		return fmt.Errorf("Subcommand %s already registered", alias)
+	}
+
+	if this.subcommand_aliases == nil {
+		this.subcommand_aliases = make(map[string]string)
+	}
+	this.subcommand_aliases[alias] = target
+
+	return nil
+}
+
+// Return the name of the subcommand the given name or alias designates
+func (this *parser) lookup_subcommand(name string) (string, bool) {
+	if _, ok := this.subcommands[name]; ok {
+		return name, true
+	}
+
+	target, ok := this.subcommand_aliases[name]
+	return target, ok
+}
+
+// Whether the given argument is the help command of a parser that has
+// subcommands, e.g. "help" in "tool help add", unless a subcommand is named so
+func (this *parser) is_help_command(arg string) bool {
+	_, ok := this.lookup_subcommand(arg)
+	return arg == "help" && !ok && len(this.subcommands) > 0
+}
+
+// Print the help of the subcommand named by the arguments of the help
+// command, or the one of the parser when none is, and exit unless errors are
+// returned
+func (this *parser) print_subcommand_help(args []string) error {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if name, ok := this.lookup_subcommand(args[0]); ok {
+			subcommand := this.subcommands[name]
+			this.pass_settings(subcommand)
+
+			// Nested subcommands are looked up in turn, e.g. tool help remote add
+			if len(subcommand.subcommands) > 0 && len(args) > 1 {
+				return subcommand.print_subcommand_help(args[1:])
+			}
+
+			subcommand.PrintHelp()
+			if this.continue_on_error {
+				return ErrHelp
+			}
+			os.Exit(0)
+		}
+
+		parsing_error(this, fmt.Errorf("Unknown subcommand %s", args[0]))
+	}
+
+	this.PrintHelp()
+	if this.continue_on_error {
+		return ErrHelp
+	}
+	os.Exit(0)
+
+	return nil
+}
+
+// Hand the settings of the parser down to one of its subcommands, when it's
+// created and again when it's dispatched to, as they can change in between
+func (this *parser) pass_settings(subcommand *parser) {
+	subcommand.help_short_flag = this.help_short_flag
+	subcommand.help_long_flag = this.help_long_flag
+	subcommand.output = this.output
+	subcommand.warning_writer = this.warning_writer
+	subcommand.continue_on_error = this.continue_on_error
+}
+
+// Return the name of the subcommand selected by the last parse, if any
+func (this *parser) SelectedSubcommand() string {
+	return this.selected_subcommand
+}
+
+// Register the flags defined on a FlagSet of the flag package, with a single
+// dash as they are spelled there, e.g. -name, their values are set by the
+// parser in place of the FlagSet
+func (this *parser) ImportStdFlagSet(fs *flag.FlagSet) error {
+	return import_std_flag_set(this, fs)
+}
+
+// Register the flags of a FlagSet on the given parser
+func import_std_flag_set(parser ArgumentParser, fs *flag.FlagSet) error {
+	var errs []error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if err := parser.Var(f.Value, "-"+f.Name, f.Usage, &VarOptions{}); err != nil {
+			errs = append(errs, err)
+		}
+	})
+
+	return errors.Join(errs...)
+}
+
+// Prevent any further registration or change to the configuration of the
+// parser, the registrations return an error and the refused changes are
+// returned by the following parses
+func (this *parser) Seal() {
+	this.sealed = true
+}
+
+// Return the spelling of the flag given on the command line during the last
+// parse, e.g. its short or negated form, or an empty string if it was absent
+func (this *parser) MatchedSpelling(flag string) string {
+	return this.spellings[flag]
+}
+
+// Return the help of a registered flag or positional, and whether it exists
+func (this *parser) FlagHelp(flag string) (string, bool) {
+	addr, ok := this.vars[flag]
+	if !ok {
+		return "", false
+	}
+
+	help := ""
+	extract_completion_details(addr, &help, new([]string), new(bool))
+
+	return help, true
+}
+
+// Return the row of the help describing one of the flags handled by the
+// parser itself, or an empty string if both its spellings are disabled
+func builtin_flag_row(short_flag, long_flag, help string) string {
+	if len(short_flag) > 0 && len(long_flag) > 0 {
+		return fmt.Sprintf("  %s, %s\t%s", short_flag, long_flag, help)
+	} else if len(short_flag) > 0 {
+		return fmt.Sprintf("  %s\t%s", short_flag, help)
+	} else if len(long_flag) > 0 {
+		return fmt.Sprintf("      %s\t%s", long_flag, help)
+	}
+
+	return ""
+}
+
+// Sort the flags listed by the help, given in the order they were registered
+func (this *parser) sort_help_flags(flags []string) []string {
+	if this.help_sort == HelpSortDeclared {
+		return flags
+	}
+
+	sorted := append([]string{}, flags...)
+	sort.Strings(sorted)
+	if this.help_sort != HelpSortGrouped {
+		return sorted
+	}
+
+	grouped := make(map[string]bool)
+	for _, group := range this.exclusive_groups {
+		for _, flag := range group.flags {
+			grouped[flag] = true
+		}
+	}
+
+	var ordered []string
+	for _, flag := range sorted {
+		if !grouped[flag] {
+			ordered = append(ordered, flag)
+		}
+	}
+
+	// A flag is only listed with the first group it belongs to
+	listed := make(map[string]bool)
+	for _, group := range this.exclusive_groups {
+		for _, flag := range group.flags {
+			if !listed[flag] && value_in_choices(flag, flags) {
+				listed[flag] = true
+				ordered = append(ordered, flag)
+			}
+		}
 	}
 
-	return parse_positionals(this, this.vars, unparsed_args)
+	return ordered
 }
 
+// Amount of flags beyond which the usage line of the help collapses them into
+// [OPTIONS], they are still listed one by one below it
+const MaxUsageFlags = 10
+
 func (this *parser) PrintHelp() {
-	// FIXME: implement
-	fmt.Printf("%s - %s\n", this.prog, this.description)
+	var flags, positionals []string
+
+	for _, flag := range this.order {
+		addr := this.vars[flag]
+		Experimental := false
+
+		if err := extract_base_options(addr, new(string), new(bool), new(int), &Experimental, new(bool)); err != nil || (Experimental && !experimental_enabled()) {
+			continue
+		}
+
+		if strings.HasPrefix(flag, "-") {
+			flags = append(flags, flag)
+		} else {
+			positionals = append(positionals, flag)
+		}
+	}
+	// Positionals are listed in the order they collect their arguments
+	flags = this.sort_help_flags(flags)
+
+	usage := []string{"Usage:", this.prog}
+	w := this.output
+	var flag_usage, flag_rows, positional_rows []string
+
+	if len(this.help_short_flag) > 0 {
+		flag_usage = append(flag_usage, "["+this.help_short_flag+"]")
+	} else if len(this.help_long_flag) > 0 {
+		flag_usage = append(flag_usage, "["+this.help_long_flag+"]")
+	}
+	if row := builtin_flag_row(this.help_short_flag, this.help_long_flag, "Print this help and exit"); len(row) > 0 {
+		flag_rows = append(flag_rows, row)
+	}
+	if row := builtin_flag_row(this.version_short_flag, this.version_long_flag, "Print the version and exit"); len(this.version) > 0 && len(row) > 0 {
+		flag_rows = append(flag_rows, row)
+	}
+
+	for _, flag := range flags {
+		addr := this.vars[flag]
+		ShortFlag := ""
+		Required := false
+		NArgs := 0
+		help := ""
+
+		extract_base_options(addr, &ShortFlag, &Required, &NArgs, new(bool), new(bool))
+		extract_completion_details(addr, &help, new([]string), new(bool))
+
+		metavar := " " + extract_metavar(addr)
+		if NArgs >= 0 {
+			metavar = strings.Repeat(metavar, NArgs)
+		} else {
+			metavar += "..."
+		}
+		spelling := flag + metavar
+		if v, isBoolVarPtr := addr.(*boolVar); isBoolVarPtr && v.options.Negatable && strings.HasPrefix(flag, "--") {
+			spelling = "--[no-]" + flag[2:] + metavar
+		}
+		if Required {
+			flag_usage = append(flag_usage, spelling)
+		} else {
+			flag_usage = append(flag_usage, "["+spelling+"]")
+		}
+
+		if len(ShortFlag) > 0 {
+			spelling = ShortFlag + ", " + spelling
+		} else {
+			spelling = "    " + spelling
+		}
+		if unit := extract_unit(addr); len(unit) > 0 {
+			spelling += " (" + unit + ")"
+		}
+
+		var details []string
+		if Required {
+			details = append(details, "required")
+		}
+		if NArgs > 1 {
+			details = append(details, fmt.Sprintf("%d values", NArgs))
+		}
+		if v, isEnumVarPtr := addr.(*enumVar); isEnumVarPtr {
+			details = append(details, "one of: "+strings.Join(enum_names(v.options.Values), ", "))
+		} else if v, isIntVarPtr := addr.(*intVar); isIntVarPtr && (v.options.Min != nil || v.options.Max != nil) {
+			details = append(details, describe_range(v.options.Min, v.options.Max))
+		} else if v, isFloatVarPtr := addr.(*floatVar); isFloatVarPtr && (v.options.Min != nil || v.options.Max != nil) {
+			details = append(details, describe_range(v.options.Min, v.options.Max))
+		}
+		for _, group := range this.exclusive_groups {
+			if !value_in_choices(flag, group.flags) {
+				continue
+			}
+
+			if group.required {
+				details = append(details, "exactly one of: "+strings.Join(group.flags, ", "))
+			} else {
+				var others []string
+				for _, other := range group.flags {
+					if other != flag {
+						others = append(others, other)
+					}
+				}
+				details = append(details, "exclusive with: "+strings.Join(others, ", "))
+			}
+		}
+		if env_var := extract_env_var(addr); len(env_var) > 0 {
+			details = append(details, "env: "+env_var)
+		}
+		if default_value := extract_default_value(addr); len(default_value) > 0 {
+			details = append(details, "default: "+default_value)
+		}
+		if len(details) > 0 {
+			help = strings.TrimSpace(help + " (" + strings.Join(details, ", ") + ")")
+		}
+
+		flag_rows = append(flag_rows, fmt.Sprintf("  %s\t%s", spelling, help))
+	}
+
+	if len(flags) > MaxUsageFlags {
+		usage = append(usage, "[OPTIONS]")
+	} else {
+		usage = append(usage, flag_usage...)
+	}
+
+	for _, name := range positionals {
+		addr := this.vars[name]
+		Required := false
+		help := ""
+
+		extract_base_options(addr, new(string), &Required, new(int), new(bool), new(bool))
+		extract_completion_details(addr, &help, new([]string), new(bool))
+
+		metavar := positional_metavar(name, addr)
+		if Required {
+			usage = append(usage, metavar)
+		} else {
+			usage = append(usage, "["+metavar+"]")
+		}
+
+		if default_value := extract_default_value(addr); len(default_value) > 0 {
+			help = strings.TrimSpace(help + " (default: " + default_value + ")")
+		}
+
+		positional_rows = append(positional_rows, fmt.Sprintf("  %s\t%s", metavar, help))
+	}
+
+	var subcommand_rows []string
+	for _, name := range this.subcommand_order {
+		names := []string{name}
+		for alias, target := range this.subcommand_aliases {
+			if target == name {
+				names = append(names, alias)
+			}
+		}
+		sort.Strings(names[1:])
+
+		subcommand_rows = append(subcommand_rows, fmt.Sprintf("  %s\t%s", strings.Join(names, ", "), this.subcommands[name].description))
+	}
+	if len(subcommand_rows) > 0 {
+		usage = append(usage, "[COMMAND ...]")
+		if _, ok := this.lookup_subcommand("help"); !ok {
+			subcommand_rows = append(subcommand_rows, "  help\tPrint the help of a command")
+		}
+	}
+
+	fmt.Fprintln(w, strings.Join(usage, " "))
+	if len(this.description) > 0 {
+		fmt.Fprintf(w, "\n%s\n", this.description)
+	}
+
+	width := TerminalWidth(w)
+	if len(flag_rows) > 0 {
+		fmt.Fprintln(w, "\nFlags:")
+		write_help_rows(w, flag_rows, width)
+	}
+	if len(positional_rows) > 0 {
+		fmt.Fprintln(w, "\nPositionals:")
+		write_help_rows(w, positional_rows, width)
+	}
+	if len(subcommand_rows) > 0 {
+		fmt.Fprintln(w, "\nCommands:")
+		write_help_rows(w, subcommand_rows, width)
+	}
+}
+
+func (this *parser) PrintWarning(err error) {
+	this.warnings = append(this.warnings, err.Error())
+	this.emit(Event{
+		Kind: EventWarning,
+		Err:  err,
+	})
+	fmt.Fprintf(this.warning_writer, "Warning: %s\n", err.Error())
+}
+
+func (this *parser) GenerateFishCompletion(w io.Writer) error {
+	prog := filepath.Base(this.prog)
+	for _, flag := range this.order {
+		addr := this.vars[flag]
+		ShortFlag := ""
+		NArgs := 0
+		Experimental := false
+		help := ""
+		isFile := false
+		var choices []string
+
+		if !strings.HasPrefix(flag, "-") {
+			continue
+		}
+
+		if err := extract_base_options(addr, &ShortFlag, new(bool), &NArgs, &Experimental, new(bool)); err != nil {
+			return err
+		} else if Experimental && !experimental_enabled() {
+			continue
+		}
+		if err := extract_completion_details(addr, &help, &choices, &isFile); err != nil {
+			return err
+		}
+
+		line := fmt.Sprintf("complete -c %s %s", prog, fish_flag_option(flag))
+		if len(ShortFlag) > 0 {
+			line += " " + fish_flag_option(ShortFlag)
+		}
+		if len(help) > 0 {
+			line += " -d " + fish_quote(help)
+		}
+		if len(choices) > 0 {
+			line += " -x -a " + fish_quote(strings.Join(choices, " "))
+		} else if isFile {
+			line += " -r -F"
+		} else if NArgs > 0 {
+			line += " -x"
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (this *parser) track_file(fd *os.File) {
+	this.open_fds = append(this.open_fds, fd)
 }
 
 func (this *parser) CloseAllOpenFiles() error {
@@ -602,3 +5385,27 @@ func (this *parser) CloseAllOpenFiles() error {
 
 	return nil
 }
+
+// Close the files opened by the previous parses and empty the slices they
+// filled, for the parser and its subcommands to be parsed again without
+// accumulating values or leaking descriptors, e.g. on a reload
+func (this *parser) Reset() error {
+	var errs []error
+
+	if err := this.CloseAllOpenFiles(); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, flag := range this.order {
+		clear_slice_placeholder(this.vars[flag])
+	}
+	this.reset_parse_state()
+
+	for _, name := range this.subcommand_order {
+		if err := this.subcommands[name].Reset(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
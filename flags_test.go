@@ -0,0 +1,2176 @@
+/*
+ * flags_test.go for flags
+ * by lenormf
+ */
+
+package flags
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Reported through OnParsingError while the tests run
+type parsingFailure struct {
+	err error
+}
+
+func TestMain(m *testing.M) {
+	// Hand the parsing errors back to the tests instead of exiting
+	OnParsingError = func(parser ArgumentParser, err error) {
+		panic(parsingFailure{err})
+	}
+
+	os.Exit(m.Run())
+}
+
+// Call the given function, and return the error it reported through
+// OnParsingError, if any
+func catch_parsing_error(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			failure, ok := r.(parsingFailure)
+			if !ok {
+				panic(r)
+			}
+
+			err = failure.err
+		}
+	}()
+
+	f()
+	return nil
+}
+
+// Parse the given arguments, and return the error reported through
+// OnParsingError, if any
+func parse(parser ArgumentParser, args []string) ([]string, error) {
+	var remaining []string
+	var err error
+
+	if failure := catch_parsing_error(func() {
+		remaining, err = parser.Parse(args)
+	}); failure != nil {
+		return nil, failure
+	}
+
+	return remaining, err
+}
+
+// Run the given function in a new process of the test binary, for the code
+// paths that exit, and return what it wrote to the standard output and its
+// exit code
+func run_in_subprocess(t *testing.T, f func()) (string, int) {
+	if os.Getenv("FLAGS_TEST_SUBPROCESS") == t.Name() {
+		f()
+		os.Exit(0)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$")
+	cmd.Env = append(os.Environ(), "FLAGS_TEST_SUBPROCESS="+t.Name())
+
+	output, err := cmd.Output()
+	var exit_error *exec.ExitError
+	if errors.As(err, &exit_error) {
+		return string(output), exit_error.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(output), 0
+}
+
+// Write a file with the given contents in a temporary directory, and return
+// its path
+func write_test_file(t *testing.T, name string, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// Return the value the given function panicked with, nil if it didn't
+func recover_panic(f func()) (r interface{}) {
+	defer func() {
+		r = recover()
+	}()
+
+	f()
+	return nil
+}
+
+func TestExperimentalFlags(t *testing.T) {
+	var secret string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&secret, "--secret", "", &StringVarOptions{NArgs: 1, Experimental: true})
+
+	t.Setenv(ExperimentalEnvVar, "")
+	if _, err := parse(parser, []string{"--secret", "x"}); err == nil {
+		t.Fatalf("experimental flag accepted without %s set", ExperimentalEnvVar)
+	}
+
+	t.Setenv(ExperimentalEnvVar, "1")
+	if _, err := parse(parser, []string{"--secret", "x"}); err != nil || secret != "x" {
+		t.Fatalf("experimental flag rejected with %s set: %v (got %q)", ExperimentalEnvVar, err, secret)
+	}
+}
+
+func TestGenerateFishCompletion(t *testing.T) {
+	var n int
+	var name, secret string
+	var verbose bool
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "A number", &IntVarOptions{ShortFlag: "-n", Choices: []int{1, 2}})
+	parser.StringVar(&name, "--name", "It's a name", &StringVarOptions{NArgs: 1})
+	parser.BoolVar(&verbose, "-verbose", "", &BoolVarOptions{})
+	parser.StringVar(&secret, "--secret", "", &StringVarOptions{NArgs: 1, Experimental: true})
+	t.Setenv(ExperimentalEnvVar, "")
+
+	var output bytes.Buffer
+	if err := parser.GenerateFishCompletion(&output); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		"complete -c prog -l number -s n -d 'A number' -x -a '1 2'",
+		"complete -c prog -l name -d 'It\\'s a name' -x",
+		"complete -c prog -o verbose",
+		"",
+	}, "\n")
+	if output.String() != expected {
+		t.Fatalf("unexpected completion:\n%s", output.String())
+	}
+}
+
+func TestNonEmpty(t *testing.T) {
+	var name string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&name, "--name", "", &StringVarOptions{NArgs: 1, NonEmpty: true})
+
+	if _, err := parse(parser, []string{"--name", "   "}); err == nil {
+		t.Fatal("blank value accepted")
+	}
+	if _, err := parse(parser, []string{"--name", " x "}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPointerToPointer(t *testing.T) {
+	var n *int
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "", &IntVarOptions{})
+
+	if _, err := parse(parser, nil); err != nil || n != nil {
+		t.Fatalf("placeholder allocated for an absent flag: %v", err)
+	}
+	if _, err := parse(parser, []string{"--number", "3"}); err != nil || n == nil || *n != 3 {
+		t.Fatalf("placeholder not allocated for a present flag: %v", err)
+	}
+}
+
+func TestHelpFlagsPerParser(t *testing.T) {
+	output, code := run_in_subprocess(t, func() {
+		first := NewArgumentsParser("first", "")
+		second := NewArgumentsParser("second", "")
+		second.SetHelpFlags("-?", "--aide")
+
+		// Only the second parser prints its help, and exits
+		if _, err := parse(first, []string{"--aide"}); err == nil {
+			fmt.Println("ignored")
+		}
+		parse(second, []string{"--aide"})
+	})
+	if code != 0 || !strings.HasPrefix(output, "ignored\n") || !strings.Contains(output, "second") {
+		t.Fatalf("unexpected help (exit code %d):\n%s", code, output)
+	}
+	if HelpShortFlag != "-h" || HelpLongFlag != "--help" {
+		t.Fatalf("package help flags changed to %s/%s", HelpShortFlag, HelpLongFlag)
+	}
+}
+
+func TestStringValueOnExist(t *testing.T) {
+	mode := "slow"
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&mode, "--fast", "", &StringVarOptions{ValueOnExist: "fast"})
+
+	if _, err := parse(parser, nil); err != nil || mode != "slow" {
+		t.Fatalf("value changed for an absent flag: %q (%v)", mode, err)
+	}
+	if _, err := parse(parser, []string{"--fast"}); err != nil || mode != "fast" {
+		t.Fatalf("expected the value on exist, got %q (%v)", mode, err)
+	}
+
+	// Without a ValueOnExist, a flag takes a value
+	name := "default"
+	parser = NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&name, "--name", "", &StringVarOptions{})
+
+	if remaining, err := parse(parser, []string{"--name", "foo"}); err != nil || name != "foo" || len(remaining) != 0 {
+		t.Fatalf("value not given to the flag: %q, %v (%v)", name, remaining, err)
+	}
+}
+
+func TestDashPositional(t *testing.T) {
+	var input string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&input, "input", "", &StringVarOptions{NArgs: 1})
+
+	if _, err := parse(parser, []string{"-"}); err != nil || input != "-" {
+		t.Fatalf("lone dash not given to the positional, got %q (%v)", input, err)
+	}
+
+	// It is the value of a flag that takes one
+	var output string
+	parser = NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&output, "--output", "", &StringVarOptions{NArgs: 1})
+
+	if remaining, err := parse(parser, []string{"--output", "-", "-"}); err != nil || output != "-" || strings.Join(remaining, " ") != "-" {
+		t.Fatalf("lone dash not given to the flag, got %q, %v (%v)", output, remaining, err)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	var dir string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&dir, "--dir", "", &StringVarOptions{NArgs: 1, ExpandEnv: true})
+	t.Setenv("FLAGS_TEST_HOME", "/home/user")
+
+	if _, err := parse(parser, []string{"--dir", "$FLAGS_TEST_HOME/src"}); err != nil || dir != "/home/user/src" {
+		t.Fatalf("variable not expanded, got %q (%v)", dir, err)
+	}
+}
+
+func TestPathVar(t *testing.T) {
+	var path, dir string
+	file := write_test_file(t, "file", "")
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.PathVar(&path, "--path", "", &PathVarOptions{MustExist: true})
+	parser.PathVar(&dir, "--dir", "", &PathVarOptions{MustBeDir: true})
+
+	if _, err := parse(parser, []string{"--path", file + ".missing"}); err == nil {
+		t.Fatal("missing path accepted")
+	}
+	if _, err := parse(parser, []string{"--dir", file}); err == nil {
+		t.Fatal("file accepted where a directory is expected")
+	}
+	if _, err := parse(parser, []string{"--path", file, "--dir", filepath.Dir(file)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetOverrideVar(t *testing.T) {
+	var overrides map[string]interface{}
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.SetOverrideVar(&overrides, "--set", "")
+
+	if _, err := parse(parser, []string{"--set", "a.b.c=1"}); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(overrides) != "map[a:map[b:map[c:1]]]" {
+		t.Fatalf("unexpected overrides %v", overrides)
+	}
+	if _, err := parse(parser, []string{"--set", "a.b.c.d=1"}); err == nil {
+		t.Fatal("override of a value with a map accepted")
+	}
+}
+
+func TestReportAllMissing(t *testing.T) {
+	var a, b, c int
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.SetReportAllMissing(true)
+	parser.IntVar(&a, "--alpha", "", &IntVarOptions{Required: true})
+	parser.IntVar(&b, "--beta", "", &IntVarOptions{Required: true})
+	parser.IntVar(&c, "--gamma", "", &IntVarOptions{Required: true})
+
+	_, err := parse(parser, nil)
+	if err == nil || err.Error() != "Missing required flags: --alpha, --beta, --gamma" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestRepeatedShortFlags(t *testing.T) {
+	var includes []string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&includes, "--include", "", &StringVarOptions{ShortFlag: "-I", NArgs: 1})
+
+	if _, err := parse(parser, []string{"-I/usr/include", "-I/opt/include"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(includes, ",") != "/usr/include,/opt/include" {
+		t.Fatalf("unexpected includes %v", includes)
+	}
+}
+
+func TestPositionalDefault(t *testing.T) {
+	var output string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&output, "OUTPUT", "", &StringVarOptions{Default: "out.txt"})
+
+	if _, err := parse(parser, nil); err != nil || output != "out.txt" {
+		t.Fatalf("expected the default, got %q (%v)", output, err)
+	}
+	if _, err := parse(parser, []string{"x.txt"}); err != nil || output != "x.txt" {
+		t.Fatalf("expected the given value, got %q (%v)", output, err)
+	}
+}
+
+func TestWarningWriter(t *testing.T) {
+	// The parser writes its regular output to the standard output it's
+	// created with
+	stdout := os.Stdout
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	os.Stdout = writer
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	var files []*os.File
+	var warnings bytes.Buffer
+	missing := filepath.Join(t.TempDir(), "missing")
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.SetWarningWriter(&warnings)
+	parser.FileVar(&files, "--input", "", &FileVarOptions{SkipUnopenable: true})
+
+	_, err = parse(parser, []string{"--input", missing})
+	writer.Close()
+	printed, _ := io.ReadAll(reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(warnings.String(), "Warning: ") || !strings.Contains(warnings.String(), missing) {
+		t.Fatalf("warning not written to the warning writer: %q", warnings.String())
+	}
+	if len(printed) > 0 {
+		t.Fatalf("warning written to the standard output: %q", printed)
+	}
+}
+
+func TestRequireEquals(t *testing.T) {
+	var value string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&value, "--flag", "", &StringVarOptions{NArgs: 1, RequireEquals: true})
+
+	if _, err := parse(parser, []string{"--flag", "value"}); err == nil {
+		t.Fatal("space separated value accepted")
+	}
+	if _, err := parse(parser, []string{"--flag=value"}); err != nil || value != "value" {
+		t.Fatalf("expected value, got %q (%v)", value, err)
+	}
+}
+
+func TestParseMap(t *testing.T) {
+	var n int
+	var s string
+	var b bool
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "", &IntVarOptions{})
+	parser.StringVar(&s, "--name", "", &StringVarOptions{NArgs: 1})
+	parser.BoolVar(&b, "--verbose", "", &BoolVarOptions{})
+
+	if err := parser.ParseMap(map[string]string{"--number": "4", "--name": "x", "--verbose": "true"}); err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 || s != "x" || !b {
+		t.Fatalf("unexpected values %d, %q, %t", n, s, b)
+	}
+	if err := parser.ParseMap(map[string]string{"--unknown": "1"}); err == nil {
+		t.Fatal("unknown flag accepted")
+	}
+
+	// The conversion errors are returned even without ContinueOnError
+	on_parsing_error := OnParsingError
+	OnParsingError = func(parser ArgumentParser, err error) {
+		t.Fatalf("OnParsingError called with %v", err)
+	}
+	defer func() {
+		OnParsingError = on_parsing_error
+	}()
+
+	if err := parser.ParseMap(map[string]string{"--number": "x"}); err == nil {
+		t.Fatal("invalid value accepted")
+	}
+}
+
+func TestHelpOnEmpty(t *testing.T) {
+	output, code := run_in_subprocess(t, func() {
+		var n int
+		parser := NewArgumentsParser("prog", "Test program")
+		parser.HelpOnEmpty(true)
+		parser.IntVar(&n, "--number", "", &IntVarOptions{Required: true})
+
+		parse(parser, nil)
+	})
+	if code != 0 || !strings.HasPrefix(output, "Usage: prog") {
+		t.Fatalf("help not printed (exit code %d):\n%s", code, output)
+	}
+}
+
+func TestPattern(t *testing.T) {
+	var id string
+	parser := NewArgumentsParser("prog", "Test program")
+	if err := parser.StringVar(&id, "--bad", "", &StringVarOptions{Pattern: "["}); err == nil {
+		t.Fatal("invalid pattern accepted")
+	}
+	parser.StringVar(&id, "--id", "", &StringVarOptions{NArgs: 1, Pattern: "[A-Za-z0-9_]+"})
+
+	if _, err := parse(parser, []string{"--id", "ab_1"}); err != nil || id != "ab_1" {
+		t.Fatalf("matching value rejected: %v", err)
+	}
+	if _, err := parse(parser, []string{"--id", "a-b"}); err == nil {
+		t.Fatal("non matching value accepted")
+	}
+}
+
+func TestLazyFile(t *testing.T) {
+	var bad *LazyFile
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.FileVar(&bad, "--input", "", &FileVarOptions{Lazy: true})
+
+	if _, err := parse(parser, []string{"--input", "/nonexistent/file"}); err != nil {
+		t.Fatalf("lazy file opened while parsing: %v", err)
+	}
+	if _, err := bad.Read(make([]byte, 1)); err == nil {
+		t.Fatal("reading a missing lazy file succeeded")
+	}
+}
+
+func TestWithPrefix(t *testing.T) {
+	var timeout, db_timeout, ro_timeout int
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&timeout, "--timeout", "", &IntVarOptions{})
+	db := parser.WithPrefix("db")
+	db.IntVar(&db_timeout, "--timeout", "", &IntVarOptions{})
+	db.WithPrefix("ro").IntVar(&ro_timeout, "--timeout", "", &IntVarOptions{})
+
+	if _, err := parse(parser, []string{"--db-timeout", "2", "--timeout", "1", "--db-ro-timeout", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	if timeout != 1 || db_timeout != 2 || ro_timeout != 3 {
+		t.Fatalf("unexpected values %d, %d, %d", timeout, db_timeout, ro_timeout)
+	}
+
+	// Short flags aren't prefixed, two facades can't both register one
+	var db_trace, http_trace bool
+	if err := db.BoolVar(&db_trace, "--trace", "", &BoolVarOptions{ShortFlag: "-t"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.WithPrefix("http").BoolVar(&http_trace, "--trace", "", &BoolVarOptions{ShortFlag: "-t"}); err == nil {
+		t.Fatal("short flag registered twice")
+	}
+}
+
+func TestParseRich(t *testing.T) {
+	var n int
+	var output string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "", &IntVarOptions{})
+	parser.StringVar(&output, "OUTPUT", "", &StringVarOptions{Default: "out"})
+
+	result, err := parser.ParseRich([]string{"--number", "3", "--", "x", "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Sources) != "map[--number:argv OUTPUT:argv]" || fmt.Sprint(result.Remaining) != "[y]" || fmt.Sprint(result.Passthrough) != "[x y]" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestRepeatedEquals(t *testing.T) {
+	var headers []string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&headers, "--header", "", &StringVarOptions{NArgs: 1})
+
+	args := []string{"--header=A:1", "--header=B:2"}
+	if _, err := parse(parser, args); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(headers, ",") != "A:1,B:2" || strings.Join(args, " ") != "--header=A:1 --header=B:2" {
+		t.Fatalf("unexpected headers %v (arguments %v)", headers, args)
+	}
+}
+
+func TestDuplicatePolicy(t *testing.T) {
+	var first, second int
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&first, "--number", "", &IntVarOptions{})
+
+	if err := parser.IntVar(&second, "--number", "", &IntVarOptions{}); err == nil {
+		t.Fatal("duplicate flag accepted")
+	}
+
+	parser.SetDuplicatePolicy(DuplicateReplace)
+	if err := parser.IntVar(&second, "--number", "", &IntVarOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parse(parser, []string{"--number", "2"}); err != nil || first != 0 || second != 2 {
+		t.Fatalf("second definition not used: %d, %d (%v)", first, second, err)
+	}
+
+	parser.SetDuplicatePolicy(DuplicateIgnore)
+	if err := parser.IntVar(&first, "--number", "", &IntVarOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parse(parser, []string{"--number", "3"}); err != nil || first != 0 || second != 3 {
+		t.Fatalf("second definition not ignored: %d, %d (%v)", first, second, err)
+	}
+}
+
+func TestNegationPrefixes(t *testing.T) {
+	var cache bool
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.SetNegationPrefixes("--disable-")
+	parser.BoolVar(&cache, "--cache", "", &BoolVarOptions{ValueOnExist: true})
+
+	if _, err := parse(parser, []string{"--cache", "--disable-cache"}); err != nil || cache {
+		t.Fatalf("flag not negated: %v", err)
+	}
+	if _, err := parse(parser, []string{"--disable-cache", "--cache"}); err != nil || !cache {
+		t.Fatalf("flag not set: %v", err)
+	}
+}
+
+func TestMustVariants(t *testing.T) {
+	var n int
+	parser := NewArgumentsParser("prog", "Test program")
+
+	if r := recover_panic(func() { parser.MustIntVar(&n, "--number", "", &IntVarOptions{}) }); r != nil {
+		t.Fatalf("unexpected panic %v", r)
+	}
+	if r := recover_panic(func() { parser.MustIntVar(&n, "--number", "", &IntVarOptions{}) }); r == nil {
+		t.Fatal("duplicate flag didn't panic")
+	}
+}
+
+func TestStrictValues(t *testing.T) {
+	var count int
+	var files []string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&count, "--count", "", &IntVarOptions{})
+	parser.StringVar(&files, "files", "", &StringVarOptions{})
+
+	if _, err := parse(parser, []string{"--count", "3", "5"}); err != nil {
+		t.Fatal(err)
+	}
+
+	parser.SetStrictValues(true)
+	if _, err := parse(parser, []string{"--count", "3", "file"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parse(parser, []string{"--count", "3", "5"}); err == nil || !strings.Contains(err.Error(), "Unexpected value 5") {
+		t.Fatalf("orphan value not reported: %v", err)
+	}
+}
+
+func TestToggle(t *testing.T) {
+	for occurrences, expected := range []bool{false, true, false, true} {
+		var toggled bool
+		parser := NewArgumentsParser("prog", "Test program")
+		parser.BoolVar(&toggled, "--toggle", "", &BoolVarOptions{ShortFlag: "-t", Toggle: true})
+
+		args := []string{}
+		for i := 0; i < occurrences; i++ {
+			args = append(args, "-t")
+		}
+
+		if _, err := parse(parser, args); err != nil || toggled != expected {
+			t.Fatalf("%d occurrences gave %t (%v)", occurrences, toggled, err)
+		}
+	}
+}
+
+func TestTrimSpaceChoices(t *testing.T) {
+	var env string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&env, "--env", "", &StringVarOptions{NArgs: 1, Choices: []string{"dev", "prod"}})
+	trimmed := NewArgumentsParser("prog", "Test program")
+	trimmed.StringVar(&env, "--env", "", &StringVarOptions{NArgs: 1, TrimSpace: true, Choices: []string{"dev", "prod"}})
+
+	if _, err := parse(parser, []string{"--env", " prod "}); err == nil {
+		t.Fatal("padded choice accepted without TrimSpace")
+	}
+	if _, err := parse(trimmed, []string{"--env", " prod "}); err != nil || env != "prod" {
+		t.Fatalf("padded choice rejected with TrimSpace: %q (%v)", env, err)
+	}
+}
+
+func TestBaseDir(t *testing.T) {
+	var path string
+	var file *os.File
+	dir := filepath.Dir(write_test_file(t, "file", "x"))
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.PathVar(&path, "--path", "", &PathVarOptions{BaseDir: dir, MustBeFile: true})
+	parser.FileVar(&file, "--file", "", &FileVarOptions{BaseDir: dir, CloseOnExit: true})
+	defer parser.CloseAllOpenFiles()
+
+	if _, err := parse(parser, []string{"--path", "file", "--file", "file"}); err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, "file") || file == nil || file.Name() != path {
+		t.Fatalf("relative path not resolved: %q", path)
+	}
+}
+
+func TestFileOpenError(t *testing.T) {
+	var file *os.File
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.FileVar(&file, "--file", "", &FileVarOptions{})
+
+	_, err := parse(parser, []string{"--file", "/nonexistent/file"})
+	var open_error *FileOpenError
+	if !errors.As(err, &open_error) || open_error.Path != "/nonexistent/file" || open_error.Perms != 0640 || !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("unexpected error %#v", err)
+	}
+}
+
+func TestAddStandardFlags(t *testing.T) {
+	var verbose bool
+	var config string
+	parser := NewArgumentsParser("prog", "Test program")
+	if err := parser.AddStandardFlags(StandardFlagsOptions{Version: "1.0", Verbose: &verbose, Config: &config}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parse(parser, []string{"-v", "--config", "x.conf"}); err != nil || !verbose || config != "x.conf" {
+		t.Fatalf("standard flags not parsed: %t, %q (%v)", verbose, config, err)
+	}
+	if remaining, err := parse(parser, []string{"-q"}); err != nil || len(remaining) != 1 {
+		t.Fatalf("unregistered -q consumed: %v (%v)", remaining, err)
+	}
+
+	output, code := run_in_subprocess(t, func() {
+		parse(parser, []string{"--version"})
+	})
+	if code != 0 || output != "prog 1.0\n" {
+		t.Fatalf("version not printed (exit code %d): %q", code, output)
+	}
+
+	var buffer bytes.Buffer
+	parser.SetOutput(&buffer)
+	parser.SetContinueOnError(true)
+	parser.SetVersionFlags("", "--show-version")
+	if _, err := parser.Parse([]string{"--show-version"}); err != ErrVersion || buffer.String() != "prog 1.0\n" {
+		t.Fatalf("version not printed for the custom flag: %q (%v)", buffer.String(), err)
+	}
+
+	// The help flags are left to SetHelpFlags
+	if _, err := parser.Parse([]string{"-h"}); err != ErrHelp {
+		t.Fatalf("expected help, got %v", err)
+	}
+	parser.SetHelpFlags("", "")
+	if remaining, err := parser.Parse([]string{"-h"}); err != nil || len(remaining) != 1 {
+		t.Fatalf("help flag not disabled: %v (%v)", remaining, err)
+	}
+
+	// The flags added through a prefixed parser are prefixed
+	var db_verbose bool
+	if err := parser.WithPrefix("db").AddStandardFlags(StandardFlagsOptions{Verbose: &db_verbose}); err == nil {
+		t.Fatal("short flag -v registered twice")
+	}
+	other := NewArgumentsParser("prog", "Test program")
+	if err := other.WithPrefix("db").AddStandardFlags(StandardFlagsOptions{Verbose: &db_verbose}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parse(other, []string{"--db-verbose"}); err != nil || !db_verbose {
+		t.Fatalf("prefixed standard flag not parsed: %v", err)
+	}
+}
+
+func TestShortFlagValidation(t *testing.T) {
+	var n int
+	parser := NewArgumentsParser("prog", "Test program")
+
+	for _, short_flag := range []string{"-ab", "--", "a"} {
+		if err := parser.IntVar(&n, "--number", "", &IntVarOptions{ShortFlag: short_flag}); err == nil {
+			t.Fatalf("invalid short flag %q accepted", short_flag)
+		}
+	}
+	if err := parser.IntVar(&n, "--number", "", &IntVarOptions{ShortFlag: "-é"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseStream(t *testing.T) {
+	var n int
+	var files []string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "", &IntVarOptions{})
+	parser.StringVar(&files, "files", "", &StringVarOptions{})
+
+	var stream strings.Builder
+	for i := 0; i < 10000; i++ {
+		stream.WriteString("file\n")
+	}
+	stream.WriteString("--number\n7\n")
+
+	if _, err := parser.ParseStream(strings.NewReader(stream.String())); err != nil || n != 7 || len(files) != 10000 {
+		t.Fatalf("unexpected values %d, %d (%v)", n, len(files), err)
+	}
+
+	// Flags are parsed as soon as their values are read, before the end of
+	// the stream
+	var verbosity int
+	matched := make(chan bool, 1)
+	parser = NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "", &IntVarOptions{})
+	parser.BoolVar(&verbosity, "--verbose", "", &BoolVarOptions{Count: true})
+	parser.SetEventHandler(func(event Event) {
+		if event.Flag == "--number" {
+			matched <- true
+		}
+	})
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.Write([]byte("--number\n8\n"))
+		select {
+		case <-matched:
+		case <-time.After(5 * time.Second):
+			writer.CloseWithError(errors.New("--number not parsed before the end of the stream"))
+			return
+		}
+
+		for i := 0; i < 100000; i++ {
+			writer.Write([]byte("--verbose\n"))
+		}
+		writer.Close()
+	}()
+
+	if remaining, err := parser.ParseStream(reader); err != nil || n != 8 || verbosity != 100000 || len(remaining) != 0 {
+		t.Fatalf("unexpected values %d, %d, %v (%v)", n, verbosity, remaining, err)
+	}
+
+	// Subcommands parse the rest of the stream
+	var force bool
+	var rest []string
+	parser = NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "", &IntVarOptions{})
+	add := parser.Subcommand("add", "Add things")
+	add.BoolVar(&force, "--force", "", &BoolVarOptions{})
+	add.StringVar(&rest, "rest", "", &StringVarOptions{})
+
+	if _, err := parser.ParseStream(strings.NewReader("--number\n9\nadd\n--force\nx\n--\n--force\n")); err != nil || n != 9 || !force || strings.Join(rest, " ") != "x --force" {
+		t.Fatalf("unexpected values %d, %v, %v (%v)", n, force, rest, err)
+	}
+}
+
+func TestRequiredSliceWithoutValues(t *testing.T) {
+	var files []string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&files, "--files", "", &StringVarOptions{Required: true})
+
+	if _, err := parse(parser, []string{"--files"}); err == nil {
+		t.Fatal("required slice flag without values accepted")
+	}
+
+	// Switches collect a value without consuming any argument
+	var switches []bool
+	parser = NewArgumentsParser("prog", "Test program")
+	parser.BoolVar(&switches, "--switch", "", &BoolVarOptions{Required: true})
+
+	if _, err := parse(parser, []string{"--switch", "--switch"}); err != nil || len(switches) != 2 {
+		t.Fatalf("required switches rejected: %v (%v)", switches, err)
+	}
+}
+
+func TestPrintHelp(t *testing.T) {
+	output, _ := run_in_subprocess(t, func() {
+		var n int
+		var name string
+		var verbose bool
+		var files []string
+		parser := NewArgumentsParser("prog", "Test program")
+		parser.IntVar(&n, "--number", "A number", &IntVarOptions{ShortFlag: "-n", Default: 3})
+		parser.StringVar(&name, "--name", "A name", &StringVarOptions{NArgs: 1, Required: true})
+		parser.BoolVar(&verbose, "--verbose", "Be loud", &BoolVarOptions{ShortFlag: "-v"})
+		parser.StringVar(&files, "files", "Input files", &StringVarOptions{})
+
+		parser.PrintHelp()
+	})
+
+	expected := `Usage: prog [-h] --name VALUE [--number VALUE] [--verbose] [FILES...]
+
+Test program
+
+Flags:
+  -h, --help          Print this help and exit
+      --name VALUE    A name (required)
+  -n, --number VALUE  A number (default: 3)
+  -v, --verbose       Be loud
+
+Positionals:
+  FILES...  Input files
+`
+	if output != expected {
+		t.Fatalf("unexpected help:\n%s", output)
+	}
+}
+
+func TestHelpChoicesMetavar(t *testing.T) {
+	var format, output string
+	var level int
+	buffer := &bytes.Buffer{}
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.SetOutput(buffer)
+	parser.StringVar(&format, "--format", "Output format", &StringVarOptions{NArgs: 1, Choices: []string{"json", "yaml", "toml"}})
+	parser.IntVar(&level, "--level", "Level", &IntVarOptions{Choices: []int{1, 2, 3}, Metavar: "LEVEL"})
+	parser.StringVar(&output, "--output", "Output file", &StringVarOptions{NArgs: 1, Metavar: "PATH"})
+	parser.PrintHelp()
+
+	for _, expected := range []string{"[--format {json|yaml|toml}]", "--format {json|yaml|toml}  Output format", "--level LEVEL", "--output PATH"} {
+		if !strings.Contains(buffer.String(), expected) {
+			t.Fatalf("%q missing from the help:\n%s", expected, buffer.String())
+		}
+	}
+}
+
+func TestHelpUnit(t *testing.T) {
+	var timeout int
+	var ratio float64
+	buffer := &bytes.Buffer{}
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.SetOutput(buffer)
+	parser.IntVar(&timeout, "--timeout", "Timeout", &IntVarOptions{ShortFlag: "-t", Unit: "seconds"})
+	parser.FloatVar(&ratio, "--ratio", "Ratio", &FloatVarOptions{})
+	parser.PrintHelp()
+
+	if !strings.Contains(buffer.String(), "-t, --timeout VALUE (seconds)  Timeout") {
+		t.Fatalf("unit missing from the help:\n%s", buffer.String())
+	}
+	if strings.Contains(buffer.String(), "--ratio VALUE (") || strings.Contains(buffer.String(), "[--timeout VALUE (seconds)]") {
+		t.Fatalf("unit shown out of place:\n%s", buffer.String())
+	}
+}
+
+func TestHelpCollapsedUsage(t *testing.T) {
+	var name string
+	var files []string
+	values := make([]int, 20)
+	buffer := &bytes.Buffer{}
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.SetOutput(buffer)
+	for i := range values {
+		parser.IntVar(&values[i], fmt.Sprintf("--flag%d", i), "", &IntVarOptions{})
+	}
+	parser.StringVar(&files, "files", "Input files", &StringVarOptions{})
+	parser.PrintHelp()
+
+	usage, listing, _ := strings.Cut(buffer.String(), "\n")
+	if usage != "Usage: prog [OPTIONS] [FILES...]" {
+		t.Fatalf("unexpected usage line %q", usage)
+	}
+	if !strings.Contains(listing, "--flag0 VALUE") || !strings.Contains(listing, "--flag19 VALUE") {
+		t.Fatalf("flags missing from the listing:\n%s", listing)
+	}
+
+	buffer.Reset()
+	parser = NewArgumentsParser("prog", "Test program")
+	parser.SetOutput(buffer)
+	parser.StringVar(&name, "--name", "", &StringVarOptions{NArgs: 1})
+	parser.PrintHelp()
+	if usage, _, _ := strings.Cut(buffer.String(), "\n"); usage != "Usage: prog [-h] [--name VALUE]" {
+		t.Fatalf("unexpected usage line %q", usage)
+	}
+}
+
+func TestHelpSort(t *testing.T) {
+	for _, test := range []struct {
+		mode     HelpSort
+		expected string
+	}{
+		{HelpSortAlpha, "--alpha --beta --mid --zeta"},
+		{HelpSortDeclared, "--zeta --beta --alpha --mid"},
+		{HelpSortGrouped, "--alpha --beta --zeta --mid"},
+	} {
+		var zeta, beta, alpha, mid bool
+		var output bytes.Buffer
+		parser := NewArgumentsParser("prog", "Test program")
+		parser.SetOutput(&output)
+		parser.SetHelpSort(test.mode)
+		parser.BoolVar(&zeta, "--zeta", "", &BoolVarOptions{})
+		parser.BoolVar(&beta, "--beta", "", &BoolVarOptions{})
+		parser.BoolVar(&alpha, "--alpha", "", &BoolVarOptions{})
+		parser.BoolVar(&mid, "--mid", "", &BoolVarOptions{})
+		parser.MutuallyExclusive("--zeta", "--mid")
+
+		parser.PrintHelp()
+		var listed []string
+		for _, line := range strings.Split(output.String(), "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+				listed = append(listed, fields[0])
+			}
+		}
+		if strings.Join(listed, " ") != test.expected {
+			t.Fatalf("flags listed in the wrong order for mode %d: %v\n%s", test.mode, listed, output.String())
+		}
+	}
+}
+
+func TestChoicesFile(t *testing.T) {
+	var colors []string
+	path := write_test_file(t, "choices", "red\n\n green \nblue\n")
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&colors, "--color", "", &StringVarOptions{NArgs: 1, ChoicesFile: path, Choices: []string{"black"}})
+
+	if _, err := parse(parser, []string{"--color", "green", "--color", "black"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parse(parser, []string{"--color", "pink"}); err == nil {
+		t.Fatal("value missing from the choices file accepted")
+	}
+
+	missing := NewArgumentsParser("prog", "Test program")
+	missing.StringVar(&colors, "--color", "", &StringVarOptions{NArgs: 1, ChoicesFile: path + ".missing"})
+	if _, err := parse(missing, []string{"--color", "red"}); err == nil {
+		t.Fatal("missing choices file not reported")
+	}
+}
+
+func TestIntAboveMaxInt32(t *testing.T) {
+	var n int
+	var ns []int
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "", &IntVarOptions{})
+	parser.IntVar(&ns, "--list", "", &IntVarOptions{})
+
+	above := strconv.FormatInt(math.MaxInt32+1, 10)
+	if _, err := parse(parser, []string{"--number", above, "--list", strconv.Itoa(math.MinInt64)}); err != nil {
+		t.Fatal(err)
+	}
+	if n != math.MaxInt32+1 || ns[0] != math.MinInt64 {
+		t.Fatalf("unexpected values %d, %v", n, ns)
+	}
+}
+
+func TestMatchedSpelling(t *testing.T) {
+	var n int
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "", &IntVarOptions{ShortFlag: "-n"})
+
+	if _, err := parse(parser, []string{"-n", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	if parser.MatchedSpelling("--number") != "-n" || parser.MatchedSpelling("--unknown") != "" {
+		t.Fatalf("unexpected spelling %q", parser.MatchedSpelling("--number"))
+	}
+}
+
+func TestEventHandler(t *testing.T) {
+	var n int
+	var files []*os.File
+	var events []Event
+	missing := filepath.Join(t.TempDir(), "missing")
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.SetWarningWriter(io.Discard)
+	parser.IntVar(&n, "--number", "", &IntVarOptions{ShortFlag: "-n"})
+	parser.FileVar(&files, "--input", "", &FileVarOptions{SkipUnopenable: true})
+	parser.SetEventHandler(func(event Event) {
+		events = append(events, event)
+	})
+
+	parse(parser, []string{"-n", "3", "--input", missing})
+	parse(parser, []string{"--number", "x"})
+
+	var stream []string
+	for _, event := range events {
+		stream = append(stream, fmt.Sprint(event.Kind, " ", event.Flag, " ", event.Spelling))
+	}
+	expected := []string{
+		fmt.Sprint(EventFlagMatched, " --number -n"),
+		fmt.Sprint(EventFlagMatched, " --input --input"),
+		fmt.Sprint(EventWarning, "  "),
+		fmt.Sprint(EventFlagMatched, " --number --number"),
+		fmt.Sprint(EventError, "  "),
+	}
+	if strings.Join(stream, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected events:\n%s", strings.Join(stream, "\n"))
+	}
+	if !strings.Contains(events[2].Err.Error(), missing) || events[4].Err == nil {
+		t.Fatalf("unexpected errors in the events: %v, %v", events[2].Err, events[4].Err)
+	}
+}
+
+func TestUnsortedChoices(t *testing.T) {
+	var n int
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "", &IntVarOptions{Choices: []int{3, 1, 2}})
+
+	if _, err := parse(parser, []string{"--number", "1"}); err != nil || n != 1 {
+		t.Fatalf("valid choice rejected: %v", err)
+	}
+	for _, value := range []string{"0", "5"} {
+		if _, err := parse(parser, []string{"--number", value}); err == nil {
+			t.Fatalf("invalid choice %s accepted", value)
+		}
+	}
+}
+
+func TestPassthrough(t *testing.T) {
+	var verbose bool
+	var files []string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.BoolVar(&verbose, "--verbose", "", &BoolVarOptions{ValueOnExist: true})
+	parser.StringVar(&files, "files", "", &StringVarOptions{})
+
+	if _, err := parse(parser, []string{"--verbose", "a", "--", "--not-a-flag", "--verbose", "-h"}); err != nil {
+		t.Fatal(err)
+	}
+	if !verbose || strings.Join(files, " ") != "a --not-a-flag --verbose -h" {
+		t.Fatalf("arguments after -- not kept verbatim: %v", files)
+	}
+}
+
+func TestUppercase(t *testing.T) {
+	var env string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&env, "--env", "", &StringVarOptions{NArgs: 1, Uppercase: true})
+
+	if _, err := parse(parser, []string{"--env", "prod"}); err != nil || env != "PROD" {
+		t.Fatalf("value not uppercased: %q (%v)", env, err)
+	}
+	if err := parser.StringVar(&env, "--both", "", &StringVarOptions{Uppercase: true, Lowercase: true}); err == nil {
+		t.Fatal("conflicting case options accepted")
+	}
+}
+
+func TestSeal(t *testing.T) {
+	var n int
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "", &IntVarOptions{})
+	parser.Seal()
+
+	if err := parser.IntVar(&n, "--other", "", &IntVarOptions{}); err == nil {
+		t.Fatal("registration on a sealed parser accepted")
+	}
+	if _, err := parse(parser, []string{"--number", "2"}); err != nil || n != 2 {
+		t.Fatalf("sealed parser failed to parse: %v", err)
+	}
+
+	// The changes refused by the setters are returned by the next parse only
+	parser.SetStrictValues(true)
+	if subcommand := parser.Subcommand("run", ""); subcommand == nil {
+		t.Fatal("no parser returned for the subcommand")
+	}
+	if _, err := parse(parser, []string{"--number", "2"}); err == nil {
+		t.Fatal("refused change not reported")
+	}
+	if _, err := parse(parser, []string{"--number", "3"}); err != nil || n != 3 {
+		t.Fatalf("refused change reported twice: %v", err)
+	}
+}
+
+func TestFlagsSharingPrefix(t *testing.T) {
+	var foo, foobar string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&foo, "--foo", "", &StringVarOptions{NArgs: 1})
+	parser.StringVar(&foobar, "--foobar", "", &StringVarOptions{NArgs: 1})
+
+	for i := 0; i < 20; i++ {
+		if _, err := parse(parser, []string{"--foobar", "b", "--foo=a"}); err != nil || foo != "a" || foobar != "b" {
+			t.Fatalf("flags sharing a prefix mixed up: %q, %q (%v)", foo, foobar, err)
+		}
+	}
+
+	// A short flag doesn't match a word it starts, only the values attached
+	// to it
+	var file string
+	parser = NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&file, "--file", "", &StringVarOptions{ShortFlag: "-f", NArgs: 1})
+
+	if remaining, err := parse(parser, []string{"-foo"}); err != nil || file != "" || strings.Join(remaining, " ") != "-foo" {
+		t.Fatalf("-f matched -foo: %q, %v (%v)", file, remaining, err)
+	}
+	if _, err := parse(parser, []string{"-f./foo"}); err != nil || file != "./foo" {
+		t.Fatalf("attached value not parsed: %q (%v)", file, err)
+	}
+}
+
+func TestNegativeNumbers(t *testing.T) {
+	var offset int
+	var position string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&offset, "--offset", "", &IntVarOptions{})
+	parser.StringVar(&position, "position", "", &StringVarOptions{NArgs: 1})
+
+	if _, err := parse(parser, []string{"--offset", "-10", "-42"}); err != nil || offset != -10 || position != "-42" {
+		t.Fatalf("negative numbers taken for flags: %d, %q (%v)", offset, position, err)
+	}
+}
+
+func TestPercentVar(t *testing.T) {
+	var opacity float64
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.PercentVar(&opacity, "--opacity", "", &PercentVarOptions{})
+
+	if _, err := parse(parser, []string{"--opacity", "75%"}); err != nil || opacity != 0.75 {
+		t.Fatalf("unexpected value %g (%v)", opacity, err)
+	}
+	if _, err := parse(parser, []string{"--opacity", "150%"}); err == nil {
+		t.Fatal("out of range percentage accepted")
+	}
+}
+
+func TestScalarPositionalNArgs(t *testing.T) {
+	var name string
+	var names []string
+	parser := NewArgumentsParser("prog", "Test program")
+
+	if err := parser.StringVar(&name, "name", "", &StringVarOptions{NArgs: 2}); err == nil {
+		t.Fatal("scalar positional collecting two values accepted")
+	}
+	if err := parser.StringVar(&names, "names", "", &StringVarOptions{NArgs: 2}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetOutput(t *testing.T) {
+	var n int
+	output := &bytes.Buffer{}
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.SetOutput(output)
+	parser.IntVar(&n, "--number", "A number", &IntVarOptions{})
+
+	parser.PrintHelp()
+	if !strings.Contains(output.String(), "--number VALUE  A number") {
+		t.Fatalf("help not written to the output:\n%s", output.String())
+	}
+}
+
+func TestSkipUnopenable(t *testing.T) {
+	var files []*os.File
+	var warnings bytes.Buffer
+	valid := write_test_file(t, "valid", "x")
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.SetWarningWriter(&warnings)
+	parser.FileVar(&files, "--input", "", &FileVarOptions{SkipUnopenable: true, CloseOnExit: true})
+	defer parser.CloseAllOpenFiles()
+
+	if _, err := parse(parser, []string{"--input", valid + ".missing", "--input", valid}); err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != valid || !strings.HasPrefix(warnings.String(), "Warning: ") {
+		t.Fatalf("unexpected files %v (warnings %q)", files, warnings.String())
+	}
+}
+
+func TestSpec(t *testing.T) {
+	parser := NewArgumentsParser("prog", "Test program")
+	count, err := parser.Spec("-n,--count int required help='the count'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verbose, _ := parser.Spec(`--verbose,-v bool help="be loud"`)
+	timeout, _ := parser.Spec("--timeout duration default=30s")
+	name, _ := parser.Spec("--name default='a b'")
+
+	for _, spec := range []string{"--bad complex", "--bad int wat", "--bad 'oops"} {
+		if _, err := parser.Spec(spec); err == nil {
+			t.Fatalf("invalid spec %q accepted", spec)
+		}
+	}
+
+	if _, err := parse(parser, []string{"-n", "4", "-v"}); err != nil {
+		t.Fatal(err)
+	}
+	if *count.(*int) != 4 || !*verbose.(*bool) || *timeout.(*time.Duration) != 30*time.Second || *name.(*string) != "a b" {
+		t.Fatal("unexpected values")
+	}
+	if _, err := parse(parser, []string{"-v"}); err == nil {
+		t.Fatal("missing required flag accepted")
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	on_parsing_error := OnParsingError
+	OnParsingError = func(parser ArgumentParser, err error) {
+		t.Fatalf("OnParsingError called with %v", err)
+	}
+	defer func() {
+		OnParsingError = on_parsing_error
+	}()
+
+	var n int
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.SetOutput(io.Discard)
+	parser.SetContinueOnError(true)
+	parser.IntVar(&n, "--number", "", &IntVarOptions{Required: true})
+
+	if _, err := parser.Parse(nil); err == nil {
+		t.Fatal("missing required flag accepted")
+	}
+	if _, err := parser.Parse([]string{"--number", "2", "-h"}); err != ErrHelp {
+		t.Fatalf("expected help, got %v", err)
+	}
+	if _, err := parser.Parse([]string{"--number", "3"}); err != nil || n != 3 {
+		t.Fatalf("unexpected value %d (%v)", n, err)
+	}
+
+	// The errors of all the flags are returned at once
+	var ratio float64
+	parser = NewArgumentsParser("prog", "Test program")
+	parser.SetOutput(io.Discard)
+	parser.SetContinueOnError(true)
+	parser.IntVar(&n, "--number", "", &IntVarOptions{})
+	parser.FloatVar(&ratio, "--ratio", "", &FloatVarOptions{})
+
+	_, err := parser.Parse([]string{"--number", "two", "--ratio", "half"})
+	if joined, ok := err.(interface{ Unwrap() []error }); !ok || len(joined.Unwrap()) != 2 {
+		t.Fatalf("expected the errors of both flags, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "--number") || !strings.Contains(err.Error(), "--ratio") {
+		t.Fatalf("flag missing from the errors: %v", err)
+	}
+}
+
+func TestPositionalOrder(t *testing.T) {
+	for i := 0; i < 30; i++ {
+		var source, destination string
+		var rest []string
+		parser := NewArgumentsParser("prog", "Test program")
+		parser.StringVar(&source, "source", "", &StringVarOptions{NArgs: 1})
+		parser.StringVar(&destination, "destination", "", &StringVarOptions{NArgs: 1})
+		parser.StringVar(&rest, "rest", "", &StringVarOptions{})
+
+		if _, err := parse(parser, []string{"a", "b", "c", "d"}); err != nil || source != "a" || destination != "b" || strings.Join(rest, "") != "cd" {
+			t.Fatalf("positionals out of order: %q, %q, %v (%v)", source, destination, rest, err)
+		}
+	}
+}
+
+func TestTerminalWidth(t *testing.T) {
+	output := &bytes.Buffer{}
+	terminal_width := TerminalWidth
+	TerminalWidth = func(w io.Writer) int {
+		if w != output {
+			t.Fatalf("width queried for the wrong writer: %v", w)
+		}
+		return 50
+	}
+	defer func() {
+		TerminalWidth = terminal_width
+	}()
+
+	var n int
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.SetOutput(output)
+	parser.IntVar(&n, "--number", "A very long help text that goes on and on for a while", &IntVarOptions{})
+
+	parser.PrintHelp()
+	if !strings.Contains(output.String(), "\n      --number VALUE  A very long help text that\n") {
+		t.Fatalf("help not wrapped at 50 columns:\n%s", output.String())
+	}
+	for _, line := range strings.Split(output.String(), "\n") {
+		if len(line) > 50 {
+			t.Fatalf("line longer than 50 columns: %q", line)
+		}
+	}
+
+	// Outputs that aren't terminals fall back to $COLUMNS
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	defer writer.Close()
+
+	t.Setenv("COLUMNS", "42")
+	if width := DefaultTerminalWidth(writer); width != 42 {
+		t.Fatalf("pipe width not taken from $COLUMNS: %d", width)
+	}
+	if width := DefaultTerminalWidth(output); width != 42 {
+		t.Fatalf("buffer width not taken from $COLUMNS: %d", width)
+	}
+	t.Setenv("COLUMNS", "")
+	if width := DefaultTerminalWidth(output); width != 80 {
+		t.Fatalf("width doesn't default to 80 columns: %d", width)
+	}
+}
+
+func TestNegatable(t *testing.T) {
+	var color bool
+	output := &bytes.Buffer{}
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.SetOutput(output)
+	parser.BoolVar(&color, "--color", "Colorize", &BoolVarOptions{ShortFlag: "-c", ValueOnExist: true, Negatable: true})
+
+	for _, test := range []struct {
+		args     []string
+		expected bool
+	}{
+		{[]string{"--color"}, true},
+		{[]string{"--no-color"}, false},
+		{[]string{"--no-color", "--color"}, true},
+	} {
+		if _, err := parse(parser, test.args); err != nil || color != test.expected {
+			t.Fatalf("%v gave %t (%v)", test.args, color, err)
+		}
+	}
+
+	color = false
+	if _, err := parse(parser, nil); err != nil || color {
+		t.Fatalf("default changed: %v", err)
+	}
+
+	parser.PrintHelp()
+	if !strings.Contains(output.String(), "-c, --[no-]color") {
+		t.Fatalf("negation missing from the help:\n%s", output.String())
+	}
+}
+
+func TestThousandsSeparator(t *testing.T) {
+	var n int
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--count", "", &IntVarOptions{AllowThousandsSeparator: true})
+	strict := NewArgumentsParser("prog", "Test program")
+	strict.IntVar(&n, "--count", "", &IntVarOptions{})
+
+	if _, err := parse(parser, []string{"--count", "1,000,000"}); err != nil || n != 1000000 {
+		t.Fatalf("unexpected value %d (%v)", n, err)
+	}
+	if _, err := parse(strict, []string{"--count", "1,000"}); err == nil {
+		t.Fatal("thousands separator accepted without the option")
+	}
+
+	// The groups of digits are checked before the separators are removed
+	for _, value := range []string{"1,0,0", ",5", "1000,000", "1,000,", "-12,34"} {
+		if _, err := parse(parser, []string{"--count", value}); err == nil {
+			t.Fatalf("misplaced thousands separator accepted in %s", value)
+		}
+	}
+	if _, err := parse(parser, []string{"--count", "-12,345"}); err != nil || n != -12345 {
+		t.Fatalf("unexpected value %d (%v)", n, err)
+	}
+}
+
+func TestRevalidateValues(t *testing.T) {
+	var n int
+	var tags []string
+	var name string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "", &IntVarOptions{Choices: []int{1, 2}})
+	parser.StringVar(&tags, "--tag", "", &StringVarOptions{NArgs: 1, Pattern: "[a-z]+"})
+	parser.StringVar(&name, "--name", "", &StringVarOptions{NArgs: 1, NonEmpty: true})
+
+	if _, err := parse(parser, []string{"--number", "1", "--tag", "ok", "--name", "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.RevalidateValues(); err != nil {
+		t.Fatal(err)
+	}
+
+	tags = append(tags, "BAD")
+	if err := parser.RevalidateValues(); err == nil {
+		t.Fatal("invalid value not reported")
+	}
+
+	// Zero values are checked too
+	tags = tags[:1]
+	n, name = 0, ""
+	if err := parser.RevalidateValues(); err == nil || !strings.Contains(err.Error(), "--number") || !strings.Contains(err.Error(), "--name") {
+		t.Fatalf("zero values not reported: %v", err)
+	}
+
+	// Flags that were never set aren't checked
+	var level int
+	parser = NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&level, "--level", "", &IntVarOptions{Choices: []int{1, 2, 3}})
+
+	if _, err := parse(parser, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.RevalidateValues(); err != nil {
+		t.Fatalf("unset flag checked: %v", err)
+	}
+}
+
+func TestCount(t *testing.T) {
+	for _, test := range []struct {
+		args     []string
+		expected int
+	}{
+		{[]string{"-v", "--verbose", "-v"}, 3},
+		{[]string{"-vvv"}, 3},
+		{nil, 0},
+	} {
+		var verbosity int
+		parser := NewArgumentsParser("prog", "Test program")
+		parser.BoolVar(&verbosity, "--verbose", "", &BoolVarOptions{ShortFlag: "-v", Count: true})
+
+		if _, err := parse(parser, test.args); err != nil || verbosity != test.expected {
+			t.Fatalf("%v gave %d (%v)", test.args, verbosity, err)
+		}
+	}
+}
+
+func TestAddVerbosity(t *testing.T) {
+	for _, test := range []struct {
+		args     []string
+		expected int
+	}{
+		{[]string{"-vv", "-q"}, 1},
+		{[]string{"--verbose", "--quiet", "-qq"}, -2},
+		{[]string{"-v", "-q"}, 0},
+		{nil, 0},
+	} {
+		var verbosity int
+		parser := NewArgumentsParser("prog", "Test program")
+		if err := parser.AddVerbosity(&verbosity); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := parse(parser, test.args); err != nil || verbosity != test.expected {
+			t.Fatalf("%v gave %d (%v)", test.args, verbosity, err)
+		}
+	}
+
+	var level int
+	parser := NewArgumentsParser("prog", "Test program")
+	if err := parser.AddVerbosity(nil); err == nil {
+		t.Fatal("nil placeholder accepted")
+	}
+	if err := parser.WithPrefix("log").AddVerbosity(&level); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parse(parser, []string{"--log-verbose", "--log-verbose", "--log-quiet"}); err != nil || level != 1 {
+		t.Fatalf("prefixed flags gave %d (%v)", level, err)
+	}
+}
+
+func TestEnumVar(t *testing.T) {
+	levels := map[string]int{"debug": 0, "info": 1, "warn": 2}
+	var level int
+	output := &bytes.Buffer{}
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.SetOutput(output)
+	if err := parser.EnumVar(&level, "--bad", "", &EnumVarOptions{Default: "trace", Values: levels}); err == nil {
+		t.Fatal("default missing from the values accepted")
+	}
+	if err := parser.EnumVar(&level, "--level", "Level", &EnumVarOptions{Default: "info", Values: levels}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parse(parser, []string{"--level", "warn"}); err != nil || level != 2 {
+		t.Fatalf("unexpected value %d (%v)", level, err)
+	}
+	if _, err := parse(parser, []string{"--level", "nope"}); err == nil {
+		t.Fatal("invalid name accepted")
+	}
+
+	parser.PrintHelp()
+	if !strings.Contains(output.String(), "one of: debug, info, warn") {
+		t.Fatalf("names missing from the help:\n%s", output.String())
+	}
+}
+
+func TestExportEnv(t *testing.T) {
+	var level int
+	var tags []string
+	var missing *int
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&level, "--log-level", "", &IntVarOptions{})
+	parser.StringVar(&tags, "--tag", "", &StringVarOptions{NArgs: 1})
+	parser.IntVar(&missing, "--missing", "", &IntVarOptions{})
+
+	// Restore the environment once done
+	for _, name := range []string{"FLAGS_TEST_LOG_LEVEL", "FLAGS_TEST_TAG"} {
+		t.Setenv(name, "")
+	}
+
+	if _, err := parse(parser, []string{"--log-level", "3", "--tag", "a", "--tag", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.ExportEnv("FLAGS_TEST_"); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("FLAGS_TEST_LOG_LEVEL") != "3" || os.Getenv("FLAGS_TEST_TAG") != "a,b" {
+		t.Fatal("values not exported")
+	}
+	if _, ok := os.LookupEnv("FLAGS_TEST_MISSING"); ok {
+		t.Fatal("absent flag exported")
+	}
+}
+
+func TestCloseOnExit(t *testing.T) {
+	var file *os.File
+	path := write_test_file(t, "file", "x")
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.FileVar(&file, "--input", "", &FileVarOptions{CloseOnExit: true})
+
+	if _, err := parse(parser, []string{"--input", path}); err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.CloseAllOpenFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Stat(); err == nil {
+		t.Fatal("file still open")
+	}
+}
+
+func TestReset(t *testing.T) {
+	var inputs []*os.File
+	var config *os.File
+	var first []*os.File
+	path := write_test_file(t, "file", "x")
+	tool := NewArgumentsParser("prog", "Test program")
+	tool.FileVar(&inputs, "--input", "", &FileVarOptions{CloseOnExit: true})
+	reload := tool.Subcommand("reload", "Reload the configuration")
+	reload.FileVar(&config, "--config", "", &FileVarOptions{CloseOnExit: true})
+
+	for i := 0; i < 10; i++ {
+		if err := tool.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parse(tool, []string{"--input", path, "--input", path, "reload", "--config", path}); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(inputs) != 2 || len(tool.(*parser).open_fds) != 2 || len(reload.(*parser).open_fds) != 1 {
+			t.Fatalf("parse %d left %d values and %d, %d open files", i, len(inputs), len(tool.(*parser).open_fds), len(reload.(*parser).open_fds))
+		}
+		if i == 0 {
+			first = append(first, inputs...)
+			first = append(first, config)
+		}
+	}
+
+	for _, fd := range first {
+		if _, err := fd.Stat(); err == nil {
+			t.Fatal("file of the first parse still open")
+		}
+	}
+}
+
+func TestIntOverflow(t *testing.T) {
+	var n int
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "", &IntVarOptions{})
+
+	over := strconv.FormatUint(uint64(math.MaxInt)+1, 10)
+	if _, err := parse(parser, []string{"--number", over}); err == nil || !strings.Contains(err.Error(), "overflows") {
+		t.Fatalf("overflow not detected: %v", err)
+	}
+	if _, err := parse(parser, []string{"--number", strconv.Itoa(math.MaxInt)}); err != nil || n != math.MaxInt {
+		t.Fatalf("unexpected value %d (%v)", n, err)
+	}
+}
+
+func TestFileAppendAndStandardStreams(t *testing.T) {
+	var log, input, output *os.File
+	path := write_test_file(t, "log", "x")
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.FileVar(&log, "--log", "", &FileVarOptions{Mode: "a", CloseOnExit: true})
+	parser.FileVar(&input, "--input", "", &FileVarOptions{CloseOnExit: true})
+	parser.FileVar(&output, "--output", "", &FileVarOptions{Mode: "w", CloseOnExit: true})
+
+	if _, err := parse(parser, []string{"--log", path, "--input", "-", "--output", "-"}); err != nil {
+		t.Fatal(err)
+	}
+	if input != os.Stdin || output != os.Stdout {
+		t.Fatal("- not resolved to the standard streams")
+	}
+
+	log.WriteString("y")
+	parser.CloseAllOpenFiles()
+	if contents, _ := os.ReadFile(path); string(contents) != "xy" {
+		t.Fatalf("file not appended to: %q", contents)
+	}
+	if _, err := os.Stdout.Stat(); err != nil {
+		t.Fatal("standard output closed")
+	}
+}
+
+func TestDisablePositionals(t *testing.T) {
+	var n int
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "", &IntVarOptions{})
+	parser.DisablePositionals(true)
+
+	if _, err := parse(parser, []string{"--number", "1", "file"}); err == nil {
+		t.Fatal("positional accepted")
+	}
+	if _, err := parse(parser, []string{"--", "file"}); err == nil {
+		t.Fatal("positional after -- accepted")
+	}
+}
+
+func TestReadMode(t *testing.T) {
+	var file *os.File
+	path := write_test_file(t, "file", "x")
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.FileVar(&file, "--input", "", &FileVarOptions{Mode: "r", CloseOnExit: true})
+	defer parser.CloseAllOpenFiles()
+
+	if _, err := parse(parser, []string{"--input", path}); err != nil || file == nil {
+		t.Fatalf("file not opened: %v", err)
+	}
+	if contents, err := io.ReadAll(file); err != nil || string(contents) != "x" {
+		t.Fatalf("file not readable: %q (%v)", contents, err)
+	}
+}
+
+type ipValue struct {
+	ip net.IP
+}
+
+func (this *ipValue) Set(value string) error {
+	if this.ip = net.ParseIP(value); this.ip == nil {
+		return fmt.Errorf("Invalid IP address: %s", value)
+	}
+
+	return nil
+}
+
+func (this *ipValue) String() string {
+	return this.ip.String()
+}
+
+func TestVar(t *testing.T) {
+	address := &ipValue{}
+	parser := NewArgumentsParser("prog", "Test program")
+	if err := parser.Var(address, "--address", "", &VarOptions{ShortFlag: "-a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parse(parser, []string{"-a", "10.0.0.1"}); err != nil || address.String() != "10.0.0.1" {
+		t.Fatalf("unexpected address %s (%v)", address, err)
+	}
+	if _, err := parse(parser, []string{"--address=nope"}); err == nil || !strings.Contains(err.Error(), "Invalid IP address") {
+		t.Fatalf("invalid address not reported: %v", err)
+	}
+}
+
+type color int
+
+var color_names = []string{"red", "green", "blue"}
+
+func (this *color) FromString(value string) error {
+	for i, name := range color_names {
+		if name == value {
+			*this = color(i)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Unknown color: %s", value)
+}
+
+func (this *color) String() string {
+	return color_names[*this]
+}
+
+func TestEnumValue(t *testing.T) {
+	var background color
+	output := &bytes.Buffer{}
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.SetOutput(output)
+	if err := parser.Var(EnumValue(&background), "--background", "Background", &VarOptions{Choices: []string{"red", "blue"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parse(parser, []string{"--background", "blue"}); err != nil || background != 2 || background.String() != "blue" {
+		t.Fatalf("unexpected color %d (%v)", background, err)
+	}
+	// A name the type knows is still refused when it isn't one of the choices
+	if _, err := parse(parser, []string{"--background", "green"}); err == nil || background != 2 {
+		t.Fatalf("color outside of the choices accepted: %d (%v)", background, err)
+	}
+
+	parser.PrintHelp()
+	if !strings.Contains(output.String(), "--background {red|blue}") {
+		t.Fatalf("choices missing from the help:\n%s", output.String())
+	}
+}
+
+func TestDumpResolved(t *testing.T) {
+	var n int
+	var tags []string
+	var timeout time.Duration
+	var missing *int
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "", &IntVarOptions{})
+	parser.StringVar(&tags, "--tag", "", &StringVarOptions{NArgs: 1})
+	parser.DurationVar(&timeout, "--timeout", "", &DurationVarOptions{})
+	parser.IntVar(&missing, "--missing", "", &IntVarOptions{})
+
+	if _, err := parse(parser, []string{"--number", "4", "--tag", "x", "--timeout", "1m"}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "resolved.json")
+	if err := parser.DumpResolved(path); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, _ := os.ReadFile(path)
+	var resolved map[string]struct {
+		Value  interface{}
+		Source string
+	}
+	if err := json.Unmarshal(contents, &resolved); err != nil {
+		t.Fatal(err)
+	}
+	if resolved["--number"].Value != 4.0 || resolved["--number"].Source != "argv" || fmt.Sprint(resolved["--tag"].Value) != "[x]" || resolved["--timeout"].Value != "1m0s" || resolved["--missing"].Value != nil {
+		t.Fatalf("unexpected dump:\n%s", contents)
+	}
+}
+
+func TestEnvVarFallback(t *testing.T) {
+	var n int
+	var name string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "", &IntVarOptions{EnvVar: "FLAGS_TEST_NUMBER", Required: true})
+	parser.StringVar(&name, "--name", "", &StringVarOptions{EnvVar: "FLAGS_TEST_NAME", NArgs: 1})
+	t.Setenv("FLAGS_TEST_NUMBER", "7")
+	t.Setenv("FLAGS_TEST_NAME", "env")
+
+	result, err := parser.ParseRich([]string{"--name", "argv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 7 || name != "argv" || result.Sources["--number"] != "env" || result.Sources["--name"] != "argv" {
+		t.Fatalf("unexpected values %d, %q (sources %v)", n, name, result.Sources)
+	}
+}
+
+func TestSignedDurations(t *testing.T) {
+	var shift, timeout time.Duration
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.DurationVar(&shift, "--shift", "", &DurationVarOptions{})
+	parser.DurationVar(&timeout, "--timeout", "", &DurationVarOptions{NonNegative: true})
+
+	if _, err := parse(parser, []string{"--shift", "-1h"}); err != nil || shift != -time.Hour {
+		t.Fatalf("unexpected value %s (%v)", shift, err)
+	}
+	if _, err := parse(parser, []string{"--timeout", "-1h"}); err == nil {
+		t.Fatal("negative duration accepted under NonNegative")
+	}
+}
+
+func TestDurationDefaultUnit(t *testing.T) {
+	var timeout time.Duration
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.DurationVar(&timeout, "--timeout", "", &DurationVarOptions{DefaultUnit: time.Second})
+	strict := NewArgumentsParser("prog", "Test program")
+	strict.DurationVar(&timeout, "--timeout", "", &DurationVarOptions{})
+
+	for _, test := range []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"30", 30 * time.Second},
+		{"1.5", 1500 * time.Millisecond},
+		{"500ms", 500 * time.Millisecond},
+		{"-2", -2 * time.Second},
+	} {
+		if _, err := parse(parser, []string{"--timeout", test.value}); err != nil || timeout != test.expected {
+			t.Fatalf("%s gave %s (%v)", test.value, timeout, err)
+		}
+	}
+	for _, value := range []string{"inf", "1e30"} {
+		if _, err := parse(parser, []string{"--timeout", value}); err == nil {
+			t.Fatalf("out of range duration %s accepted", value)
+		}
+	}
+	if _, err := parse(strict, []string{"--timeout", "30"}); err == nil {
+		t.Fatal("bare number accepted without a default unit")
+	}
+}
+
+func TestVariadic(t *testing.T) {
+	var includes []string
+	var verbose bool
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.StringVar(&includes, "--includes", "", &StringVarOptions{NArgs: -1})
+	parser.BoolVar(&verbose, "--verbose", "", &BoolVarOptions{ValueOnExist: true})
+
+	if _, err := parse(parser, []string{"--includes", "a", "b", "--verbose"}); err != nil || strings.Join(includes, ",") != "a,b" || !verbose {
+		t.Fatalf("collection not stopped by a flag: %v (%v)", includes, err)
+	}
+	includes = nil
+	if _, err := parse(parser, []string{"--includes", "c", "d"}); err != nil || strings.Join(includes, ",") != "c,d" {
+		t.Fatalf("collection not stopped by the end of the input: %v (%v)", includes, err)
+	}
+	if _, err := parse(parser, []string{"--includes", "--verbose"}); err == nil {
+		t.Fatal("variadic flag without values accepted")
+	}
+}
+
+func TestPlaceholderTypes(t *testing.T) {
+	var s string
+	var n int
+	parser := NewArgumentsParser("prog", "Test program")
+
+	for name, register := range map[string]func() error{
+		"IntVar":      func() error { return parser.IntVar(&s, "--a", "", &IntVarOptions{}) },
+		"FileVar":     func() error { return parser.FileVar(&s, "--b", "", &FileVarOptions{}) },
+		"StringVar":   func() error { return parser.StringVar(&n, "--c", "", &StringVarOptions{}) },
+		"BoolVar":     func() error { return parser.BoolVar(&s, "--d", "", &BoolVarOptions{}) },
+		"PathVar":     func() error { return parser.PathVar(&n, "--e", "", &PathVarOptions{}) },
+		"FloatVar":    func() error { return parser.FloatVar(&n, "--f", "", &FloatVarOptions{}) },
+		"Int64Var":    func() error { return parser.Int64Var(&n, "--g", "", &Int64VarOptions{}) },
+		"UintVar":     func() error { return parser.UintVar(&n, "--h", "", &UintVarOptions{}) },
+		"DurationVar": func() error { return parser.DurationVar(&n, "--i", "", &DurationVarOptions{}) },
+		"EnumVar":     func() error { return parser.EnumVar(&s, "--j", "", &EnumVarOptions{Values: map[string]int{"a": 1}}) },
+	} {
+		if err := register(); err == nil {
+			t.Fatalf("%s accepted a wrong placeholder type", name)
+		}
+	}
+}
+
+func TestMinMax(t *testing.T) {
+	var port int
+	min, max := 1, 65535
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&port, "--port", "", &IntVarOptions{Min: &min, Max: &max, Choices: []int{0, 80, 70000}})
+
+	if _, err := parse(parser, []string{"--port", "80"}); err != nil || port != 80 {
+		t.Fatalf("unexpected value %d (%v)", port, err)
+	}
+	for _, value := range []string{"0", "70000", "81"} {
+		if _, err := parse(parser, []string{"--port", value}); err == nil {
+			t.Fatalf("value %s accepted", value)
+		}
+	}
+	if err := parser.IntVar(&port, "--empty", "", &IntVarOptions{Min: &max, Max: &min}); err == nil {
+		t.Fatal("empty range accepted")
+	}
+}
+
+func TestRangeListVar(t *testing.T) {
+	var pages []int
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.RangeListVar(&pages, "--pages", "", &RangeListVarOptions{})
+
+	if _, err := parse(parser, []string{"--pages", "1-3,5"}); err != nil || fmt.Sprint(pages) != "[1 2 3 5]" {
+		t.Fatalf("unexpected pages %v (%v)", pages, err)
+	}
+	for _, value := range []string{"1-", "a", "3-1", "1,,2"} {
+		if _, err := parse(parser, []string{"--pages", value}); err == nil {
+			t.Fatalf("invalid range %q accepted", value)
+		}
+	}
+}
+
+func TestSubcommands(t *testing.T) {
+	var verbose, force bool
+	var name string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.BoolVar(&verbose, "--verbose", "", &BoolVarOptions{ValueOnExist: true})
+	add := parser.Subcommand("add", "Add things")
+	add.BoolVar(&force, "--force", "", &BoolVarOptions{ValueOnExist: true})
+	add.StringVar(&name, "name", "", &StringVarOptions{NArgs: 1})
+	parser.Subcommand("remove", "Remove things")
+
+	if _, err := parse(parser, []string{"--verbose", "add", "--force", "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if !verbose || !force || name != "x" || parser.SelectedSubcommand() != "add" {
+		t.Fatalf("arguments not routed to the subcommand: %t, %t, %q", verbose, force, name)
+	}
+	if _, err := parse(parser, []string{"remove"}); err != nil || parser.SelectedSubcommand() != "remove" {
+		t.Fatalf("unexpected subcommand %q (%v)", parser.SelectedSubcommand(), err)
+	}
+
+	// The settings of the parent changed after the subcommand was created are
+	// used when dispatching to it
+	var output bytes.Buffer
+	parser.SetOutput(&output)
+	parser.SetContinueOnError(true)
+	if _, err := parse(parser, []string{"add", "--help"}); err != ErrHelp || !strings.HasPrefix(output.String(), "Usage: prog add") {
+		t.Fatalf("help of the subcommand not printed: %q (%v)", output.String(), err)
+	}
+}
+
+func TestSubcommandTrailingFlags(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		var verbose, force bool
+		var files []string
+		parser := NewArgumentsParser("prog", "Test program")
+		parser.BoolVar(&verbose, "--verbose", "", &BoolVarOptions{})
+		add := parser.Subcommand("add", "Add things")
+		add.BoolVar(&force, "--force", "", &BoolVarOptions{ShortFlag: "-f"})
+		add.StringVar(&files, "files", "", &StringVarOptions{})
+
+		args := []string{"--verbose", "add", "x", "--force", "y"}
+		var err error
+		if stream {
+			_, err = parser.ParseStream(strings.NewReader(strings.Join(args, "\n")))
+		} else {
+			_, err = parse(parser, args)
+		}
+		if err != nil || !verbose || !force || fmt.Sprint(files) != "[x y]" {
+			t.Fatalf("trailing flag not parsed by the subcommand (stream: %t): %t, %t, %v (%v)", stream, verbose, force, files, err)
+		}
+	}
+}
+
+func TestSubcommandAlias(t *testing.T) {
+	var amend, force bool
+	output := &bytes.Buffer{}
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.SetOutput(output)
+	commit := parser.Subcommand("commit", "Record changes")
+	commit.BoolVar(&amend, "--amend", "", &BoolVarOptions{})
+	push := parser.Subcommand("push", "Update the remote")
+	push.BoolVar(&force, "--force", "", &BoolVarOptions{})
+
+	if err := parser.AddSubcommandAlias("ci", "commit"); err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.AddSubcommandAlias("co", "checkout"); err == nil {
+		t.Fatal("alias of an unknown subcommand accepted")
+	}
+	if err := parser.AddSubcommandAlias("push", "commit"); err == nil {
+		t.Fatal("alias shadowing a subcommand accepted")
+	}
+
+	if _, err := parse(parser, []string{"ci", "--amend"}); err != nil || !amend || parser.SelectedSubcommand() != "commit" {
+		t.Fatalf("alias not dispatched to its subcommand: %t, %q (%v)", amend, parser.SelectedSubcommand(), err)
+	}
+	amend = false
+	if _, err := parser.ParseStream(strings.NewReader("ci\n--amend")); err != nil || !amend || parser.SelectedSubcommand() != "commit" {
+		t.Fatalf("alias not dispatched to its subcommand: %t, %q (%v)", amend, parser.SelectedSubcommand(), err)
+	}
+
+	parser.PrintHelp()
+	if !strings.Contains(output.String(), "  commit, ci  Record changes") {
+		t.Fatalf("alias missing from the help:\n%s", output.String())
+	}
+}
+
+func TestSubcommandHelp(t *testing.T) {
+	var verbose, force, prune bool
+	output := &bytes.Buffer{}
+	parser := NewArgumentsParser("tool", "Test program")
+	parser.SetOutput(output)
+	parser.SetContinueOnError(true)
+	parser.BoolVar(&verbose, "--verbose", "Be loud", &BoolVarOptions{})
+	add := parser.Subcommand("add", "Add things")
+	add.BoolVar(&force, "--force", "Overwrite things", &BoolVarOptions{})
+	remote := parser.Subcommand("remote", "Manage remotes")
+	remote.Subcommand("prune", "Prune remotes").BoolVar(&prune, "--dry-run", "Only list them", &BoolVarOptions{})
+
+	for _, test := range []struct {
+		args     []string
+		expected string
+		shadowed string
+	}{
+		{[]string{"add", "--help"}, "--force", "--verbose"},
+		{[]string{"help", "add"}, "--force", "--verbose"},
+		{[]string{"help", "remote", "prune"}, "--dry-run", "--force"},
+		{[]string{"help"}, "Print the help of a command", "--force"},
+	} {
+		output.Reset()
+		if _, err := parse(parser, test.args); err != ErrHelp {
+			t.Fatalf("%v didn't print the help: %v", test.args, err)
+		}
+		if !strings.Contains(output.String(), test.expected) || strings.Contains(output.String(), test.shadowed) {
+			t.Fatalf("%v printed the wrong help:\n%s", test.args, output.String())
+		}
+
+		output.Reset()
+		if _, err := parser.ParseStream(strings.NewReader(strings.Join(test.args, "\n"))); err != ErrHelp || !strings.Contains(output.String(), test.expected) {
+			t.Fatalf("%v didn't print the help from a stream:\n%s (%v)", test.args, output.String(), err)
+		}
+	}
+
+	if _, err := parse(parser, []string{"help", "nope"}); err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Fatalf("unknown subcommand not reported: %v", err)
+	}
+}
+
+func TestDefaultString(t *testing.T) {
+	var n int
+	var timeout time.Duration
+	parser := NewArgumentsParser("prog", "Test program")
+
+	if err := parser.IntVar(&n, "--number", "", &IntVarOptions{DefaultString: "0x2A"}); err != nil || n != 42 {
+		t.Fatalf("unexpected default %d (%v)", n, err)
+	}
+	if err := parser.DurationVar(&timeout, "--timeout", "", &DurationVarOptions{DefaultString: "1m"}); err != nil || timeout != time.Minute {
+		t.Fatalf("unexpected default %s (%v)", timeout, err)
+	}
+	if err := parser.IntVar(&n, "--bad", "", &IntVarOptions{DefaultString: "x"}); err == nil {
+		t.Fatal("invalid default accepted")
+	}
+	if _, ok := parser.FlagHelp("--bad"); ok {
+		t.Fatal("flag with an invalid default registered")
+	}
+
+	// A replacement with an invalid default leaves the previous flag in place
+	var other int
+	parser.SetDuplicatePolicy(DuplicateReplace)
+	if err := parser.IntVar(&other, "--number", "", &IntVarOptions{DefaultString: "x"}); err == nil {
+		t.Fatal("invalid default accepted")
+	}
+	if _, err := parse(parser, []string{"--number", "3"}); err != nil || n != 3 || other != 0 {
+		t.Fatalf("previous flag lost: %d, %d (%v)", n, other, err)
+	}
+}
+
+func TestResponseFiles(t *testing.T) {
+	inner := write_test_file(t, "inner", "  file2\t")
+	outer := write_test_file(t, "outer", "--number 3\nfile1 @"+inner+"\n")
+	cycle := filepath.Join(t.TempDir(), "cycle")
+	os.WriteFile(cycle, []byte("@"+cycle), 0644)
+
+	var n int
+	var files []string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "", &IntVarOptions{})
+	parser.StringVar(&files, "files", "", &StringVarOptions{})
+
+	if _, err := parse(parser, []string{"@" + outer, "file3", "--", "@x"}); err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || strings.Join(files, ",") != "file1,file2,file3,@x" {
+		t.Fatalf("unexpected values %d, %v", n, files)
+	}
+	if _, err := parse(parser, []string{"@" + cycle}); err == nil || !strings.Contains(err.Error(), "Too many nested") {
+		t.Fatalf("cycle not reported: %v", err)
+	}
+}
+
+func TestStopTokens(t *testing.T) {
+	var verbose bool
+	var command string
+	var files []string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.BoolVar(&verbose, "--verbose", "", &BoolVarOptions{})
+	parser.StringVar(&command, "--command", "", &StringVarOptions{NArgs: 1})
+	parser.StringVar(&files, "files", "", &StringVarOptions{})
+	parser.SetStopTokens("exec")
+
+	remaining, err := parse(parser, []string{"--command", "exec", "a", "exec", "ls", "--verbose"})
+	if err != nil || verbose || command != "exec" || strings.Join(files, ",") != "a" || strings.Join(remaining, " ") != "exec ls --verbose" {
+		t.Fatalf("unexpected values %t, %q, %v, %v (%v)", verbose, command, files, remaining, err)
+	}
+}
+
+func TestFlagHelp(t *testing.T) {
+	var n, port int
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.IntVar(&n, "--number", "A number", &IntVarOptions{})
+	db := parser.WithPrefix("db")
+	db.IntVar(&port, "--port", "Port", &IntVarOptions{})
+
+	if help, ok := parser.FlagHelp("--number"); !ok || help != "A number" {
+		t.Fatalf("unexpected help %q", help)
+	}
+	if help, ok := db.FlagHelp("--port"); !ok || help != "Port" {
+		t.Fatalf("unexpected help %q for the prefixed flag", help)
+	}
+	if _, ok := parser.FlagHelp("--unknown"); ok {
+		t.Fatal("help found for an unknown flag")
+	}
+}
+
+func TestBoolSwitch(t *testing.T) {
+	var verbose, cache bool
+	var files []string
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.BoolVar(&verbose, "--verbose", "", &BoolVarOptions{ShortFlag: "-v"})
+	parser.BoolVar(&cache, "--cache", "", &BoolVarOptions{Default: true})
+	parser.StringVar(&files, "files", "", &StringVarOptions{})
+
+	if _, err := parse(parser, []string{"--verbose", "file"}); err != nil || !verbose || strings.Join(files, ",") != "file" {
+		t.Fatalf("switch took a value: %t, %v (%v)", verbose, files, err)
+	}
+
+	files = nil
+	if _, err := parse(parser, []string{"--verbose=false", "--cache=false"}); err != nil || verbose || cache || len(files) > 0 {
+		t.Fatalf("assigned values not parsed: %t, %t, %v (%v)", verbose, cache, files, err)
+	}
+}
+
+func TestMutuallyExclusive(t *testing.T) {
+	var json, yaml bool
+	parser := NewArgumentsParser("prog", "Test program")
+	parser.BoolVar(&json, "--json", "", &BoolVarOptions{})
+	parser.BoolVar(&yaml, "--yaml", "", &BoolVarOptions{})
+	if err := parser.MutuallyExclusive("--json", "--yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		args  []string
+		valid bool
+	}{
+		{nil, true},
+		{[]string{"--json"}, true},
+		{[]string{"--json", "--yaml"}, false},
+	} {
+		if _, err := parse(parser, test.args); (err == nil) != test.valid {
+			t.Fatalf("%v gave %v", test.args, err)
+		}
+	}
+
+	// The flags of a group declared on a prefixed parser are prefixed
+	var a, b bool
+	db := parser.WithPrefix("db")
+	db.BoolVar(&a, "--a", "", &BoolVarOptions{})
+	db.BoolVar(&b, "--b", "", &BoolVarOptions{})
+	if err := db.MutuallyExclusiveRequired("--a", "--b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parse(parser, nil); err == nil {
+		t.Fatal("required group without flags accepted")
+	}
+	if _, err := parse(parser, []string{"--db-a"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportStdFlagSet(t *testing.T) {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	count := set.Int("count", 1, "How many")
+	name := set.String("name", "a", "Name")
+	debug := set.Bool("debug", false, "")
+	parser := NewArgumentsParser("prog", "Test program")
+	if err := parser.ImportStdFlagSet(set); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parse(parser, []string{"-count", "5", "-name=bob", "-debug"}); err != nil {
+		t.Fatal(err)
+	}
+	if *count != 5 || *name != "bob" || !*debug {
+		t.Fatalf("unexpected values %d, %q, %t", *count, *name, *debug)
+	}
+	if _, err := parse(parser, []string{"-debug=false"}); err != nil || *debug {
+		t.Fatalf("assigned value not parsed: %t (%v)", *debug, err)
+	}
+	if _, err := parse(parser, []string{"-count", "x"}); err == nil {
+		t.Fatal("invalid value accepted")
+	}
+
+	prefixed := flag.NewFlagSet("test", flag.ContinueOnError)
+	port := prefixed.Int("port", 0, "")
+	if err := parser.WithPrefix("db").ImportStdFlagSet(prefixed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parse(parser, []string{"-db-port", "4"}); err != nil || *port != 4 {
+		t.Fatalf("prefixed flag not parsed: %d (%v)", *port, err)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || dragonfly)
+
+/*
+ * terminal_other.go for flags
+ * by lenormf
+ */
+
+package flags
+
+// The size of the terminal isn't queried on this platform
+func terminal_columns(fd uintptr) int {
+	return 0
+}
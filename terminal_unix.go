@@ -0,0 +1,27 @@
+//go:build linux || darwin || freebsd || netbsd || dragonfly
+
+/*
+ * terminal_unix.go for flags
+ * by lenormf
+ */
+
+package flags
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Return the number of columns of the terminal behind the file descriptor, or 0
+// if it isn't a terminal
+func terminal_columns(fd uintptr) int {
+	var size struct {
+		rows, columns, xpixels, ypixels uint16
+	}
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size))); errno != 0 {
+		return 0
+	}
+
+	return int(size.columns)
+}